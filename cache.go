@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// CacheMode controls how a generate/edit call interacts with the on-disk
+// cache.
+type CacheMode string
+
+const (
+	// CacheModeReadWrite serves a cached result when present and writes a
+	// new entry on a miss. This is the default.
+	CacheModeReadWrite CacheMode = "read_write"
+	// CacheModeBypass skips the cache entirely: no lookup, no write.
+	CacheModeBypass CacheMode = "bypass"
+	// CacheModeRefresh forces a fresh generation and overwrites any
+	// existing cache entry for the computed digest.
+	CacheModeRefresh CacheMode = "refresh"
+)
+
+const cacheDirName = "cache"
+
+// cacheSidecar is the small JSON file stored next to each cached PNG.
+type cacheSidecar struct {
+	Prompt    string    `json:"prompt"`
+	Model     string    `json:"model"`
+	InputHash string    `json:"inputHash,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	MimeType  string    `json:"mimeType"`
+}
+
+// cacheDigest computes a SHA-256 hash over the canonical tuple of inputs
+// that determine a generation's output: the model, the prompt, and the
+// mime type plus bytes of an optional input image. Callers append future
+// generation params to the same stream if they start affecting output.
+func cacheDigest(model, prompt, inputMimeType string, inputBytes []byte) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write([]byte(prompt))
+	h.Write([]byte{0})
+	h.Write([]byte(inputMimeType))
+	h.Write([]byte{0})
+	h.Write(inputBytes)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// composeCacheDigest is cacheDigest for compose_images, which sends
+// several input images instead of cacheDigest's single optional one.
+func composeCacheDigest(model, prompt string, inputs [][]byte) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write([]byte(prompt))
+	for _, b := range inputs {
+		h.Write([]byte{0})
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashBytes returns the hex SHA-256 digest of b, used for the sidecar's
+// inputHash field so callers can tell which input image a cached result
+// was derived from without re-reading it.
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Server) cacheDir() string {
+	return filepath.Join(".", s.outputDir, cacheDirName)
+}
+
+func (s *Server) cachePaths(digest string) (pngPath, jsonPath string) {
+	dir := s.cacheDir()
+	return filepath.Join(dir, digest+".png"), filepath.Join(dir, digest+".json")
+}
+
+// lookupCache returns the cached image (base64-encoded) and its sidecar
+// metadata for digest, if both the PNG and sidecar exist on disk.
+func (s *Server) lookupCache(digest string) (imageB64 string, sidecar cacheSidecar, ok bool) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+
+	pngPath, jsonPath := s.cachePaths(digest)
+
+	sidecarBytes, err := os.ReadFile(jsonPath)
+	if err != nil {
+		return "", cacheSidecar{}, false
+	}
+	if err := json.Unmarshal(sidecarBytes, &sidecar); err != nil {
+		return "", cacheSidecar{}, false
+	}
+
+	pngBytes, err := os.ReadFile(pngPath)
+	if err != nil {
+		return "", cacheSidecar{}, false
+	}
+
+	return base64.StdEncoding.EncodeToString(pngBytes), sidecar, true
+}
+
+// writeCache persists imageBytes and its sidecar under digest, then evicts
+// old entries if the server is configured with cache limits.
+func (s *Server) writeCache(digest string, imageBytes []byte, sidecar cacheSidecar) error {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+
+	dir := s.cacheDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	pngPath, jsonPath := s.cachePaths(digest)
+
+	if err := os.WriteFile(pngPath, imageBytes, 0644); err != nil {
+		return err
+	}
+
+	sidecarBytes, err := json.Marshal(sidecar)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(jsonPath, sidecarBytes, 0644); err != nil {
+		return err
+	}
+
+	s.evictCache()
+	return nil
+}
+
+type cacheEntry struct {
+	digest  string
+	size    int64
+	modTime time.Time
+}
+
+// evictCache removes the oldest cache entries until the server's
+// cacheMaxEntries and cacheMaxBytes limits are satisfied. Both limits are
+// optional; a zero value disables that limit. Failures are ignored
+// rather than returned or logged, since eviction is best-effort and must
+// never fail a generate/edit call. Callers must hold s.cacheMu; it's only
+// ever called from writeCache, which already does.
+func (s *Server) evictCache() {
+	if s.cacheMaxEntries <= 0 && s.cacheMaxBytes <= 0 {
+		return
+	}
+
+	dir := s.cacheDir()
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	entries := make([]cacheEntry, 0, len(files)/2)
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".png" {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, cacheEntry{
+			digest:  f.Name()[:len(f.Name())-len(".png")],
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+
+	i := 0
+	for (s.cacheMaxEntries > 0 && len(entries)-i > s.cacheMaxEntries) ||
+		(s.cacheMaxBytes > 0 && total > s.cacheMaxBytes) {
+		pngPath, jsonPath := s.cachePaths(entries[i].digest)
+		_ = os.Remove(pngPath)
+		_ = os.Remove(jsonPath)
+		total -= entries[i].size
+		i++
+	}
+}
+
+// validateCacheMode reports whether mode is a recognized CacheMode, a
+// blank mode included since that means "use the default".
+func validateCacheMode(mode CacheMode) error {
+	switch mode {
+	case "", CacheModeReadWrite, CacheModeBypass, CacheModeRefresh:
+		return nil
+	default:
+		return fmt.Errorf("cache_mode must be one of: read_write, bypass, refresh")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genai"
+)
+
+// fakeGeminiModels is a fake implementation of geminiModels used to test
+// the cache path without calling the real Gemini API.
+type fakeGeminiModels struct {
+	calls  int
+	result *genai.GenerateContentResponse
+	err    error
+}
+
+func (f *fakeGeminiModels) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	f.calls++
+	return f.result, f.err
+}
+
+const onePixelPNGBase64 = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8z8DwHwAFBQIAX8jx0gAAAABJRU5ErkJggg=="
+
+func fakeGenerateContentResponse(imageBase64 string) *genai.GenerateContentResponse {
+	imageBytes, _ := base64.StdEncoding.DecodeString(imageBase64)
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{
+			{
+				Content: &genai.Content{
+					Parts: []*genai.Part{
+						{InlineData: &genai.Blob{MIMEType: "image/png", Data: imageBytes}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func newCacheTestServer(t *testing.T, client geminiModels) (*Server, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	originalDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tmpDir))
+	t.Cleanup(func() { _ = os.Chdir(originalDir) })
+
+	return &Server{client: client, outputDir: defaultOutputDir}, tmpDir
+}
+
+func TestGenerateImageCacheMissThenHit(t *testing.T) {
+	fake := &fakeGeminiModels{result: fakeGenerateContentResponse(onePixelPNGBase64)}
+	s, _ := newCacheTestServer(t, fake)
+
+	_, err := s.generateImage(context.Background(), map[string]any{"prompt": "a red circle"})
+	require.NoError(t, err)
+	require.Equal(t, 1, fake.calls, "miss should call the API")
+
+	result, err := s.generateImage(context.Background(), map[string]any{"prompt": "a red circle"})
+	require.NoError(t, err)
+	require.Equal(t, 1, fake.calls, "hit should not call the API again")
+	require.Contains(t, result.Content[0].Text, "cache: hit")
+}
+
+func TestGenerateImageCacheBypassedWhenMultipleImagesRequested(t *testing.T) {
+	fake := &fakeGeminiModels{result: fakeGenerateContentResponse(onePixelPNGBase64)}
+	s, _ := newCacheTestServer(t, fake)
+
+	result, err := s.generateImage(context.Background(), map[string]any{"prompt": "a red circle", "number_of_images": 3})
+	require.NoError(t, err)
+	require.Equal(t, 3, fake.calls)
+	require.Len(t, result.Content, 6, "3 images should each produce a text+image content pair")
+
+	result, err = s.generateImage(context.Background(), map[string]any{"prompt": "a red circle", "number_of_images": 3})
+	require.NoError(t, err)
+	require.Equal(t, 6, fake.calls, "a cache entry holding one image must not satisfy a number_of_images > 1 request")
+	require.Len(t, result.Content, 6)
+}
+
+func TestGenerateImageCacheBypass(t *testing.T) {
+	fake := &fakeGeminiModels{result: fakeGenerateContentResponse(onePixelPNGBase64)}
+	s, _ := newCacheTestServer(t, fake)
+
+	_, err := s.generateImage(context.Background(), map[string]any{"prompt": "a red circle"})
+	require.NoError(t, err)
+
+	_, err = s.generateImage(context.Background(), map[string]any{"prompt": "a red circle", "cache_mode": "bypass"})
+	require.NoError(t, err)
+	require.Equal(t, 2, fake.calls, "bypass should always call the API")
+}
+
+func TestGenerateImageCacheRefresh(t *testing.T) {
+	fake := &fakeGeminiModels{result: fakeGenerateContentResponse(onePixelPNGBase64)}
+	s, _ := newCacheTestServer(t, fake)
+
+	_, err := s.generateImage(context.Background(), map[string]any{"prompt": "a red circle"})
+	require.NoError(t, err)
+
+	_, err = s.generateImage(context.Background(), map[string]any{"prompt": "a red circle", "cache_mode": "refresh"})
+	require.NoError(t, err)
+	require.Equal(t, 2, fake.calls, "refresh should call the API even on a hit")
+}
+
+func TestGenerateImageRejectsWebPBeforeCallingAPI(t *testing.T) {
+	fake := &fakeGeminiModels{result: fakeGenerateContentResponse(onePixelPNGBase64)}
+	s, _ := newCacheTestServer(t, fake)
+
+	_, err := s.generateImage(context.Background(), map[string]any{"prompt": "a red circle", "output_format": "webp"})
+	require.Error(t, err)
+	require.Equal(t, 0, fake.calls, "an output_format that can never succeed should be rejected before the upstream call")
+}
+
+// TestCacheConcurrentWriteAndLookup exercises writeCache (and the eviction
+// it triggers) racing against lookupCache from many goroutines at once, the
+// scenario HTTPTransport introduced by dispatching each /rpc call on its own
+// goroutine into the same Server. Before cacheMu, evictCache could remove a
+// PNG that a concurrent lookupCache was mid-read on.
+func TestCacheConcurrentWriteAndLookup(t *testing.T) {
+	s, _ := newCacheTestServer(t, nil)
+	s.cacheMaxEntries = 2
+
+	imageBytes, err := base64.StdEncoding.DecodeString(onePixelPNGBase64)
+	require.NoError(t, err)
+
+	const n = 20
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		digest := fmt.Sprintf("digest-%d", i)
+		wg.Add(2)
+		go func(digest string) {
+			defer wg.Done()
+			errs <- s.writeCache(digest, imageBytes, cacheSidecar{Prompt: digest})
+		}(digest)
+		go func(digest string) {
+			defer wg.Done()
+			s.lookupCache(digest)
+		}(digest)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestCacheDigestStable(t *testing.T) {
+	a := cacheDigest(geminiModel, "a prompt", "", nil)
+	b := cacheDigest(geminiModel, "a prompt", "", nil)
+	require.Equal(t, a, b)
+
+	c := cacheDigest(geminiModel, "a different prompt", "", nil)
+	require.NotEqual(t, a, c)
+}
+
+func TestEvictCacheMaxEntries(t *testing.T) {
+	s, tmpDir := newCacheTestServer(t, nil)
+	s.cacheMaxEntries = 1
+
+	imageBytes, err := base64.StdEncoding.DecodeString(onePixelPNGBase64)
+	require.NoError(t, err)
+
+	require.NoError(t, s.writeCache("digest-one", imageBytes, cacheSidecar{Prompt: "one"}))
+	require.NoError(t, s.writeCache("digest-two", imageBytes, cacheSidecar{Prompt: "two"}))
+
+	entries, err := os.ReadDir(filepath.Join(tmpDir, defaultOutputDir, cacheDirName))
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "one PNG and one sidecar should remain")
+}
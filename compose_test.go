@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestImage(t *testing.T, dir, name string, size int) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, make([]byte, size), 0644))
+	return path
+}
+
+func TestComposeImagesHappyPath(t *testing.T) {
+	fake := &fakeGeminiModels{result: fakeGenerateContentResponse(onePixelPNGBase64)}
+	s, tmpDir := newCacheTestServer(t, fake)
+
+	imgA := writeTestImage(t, tmpDir, "a.png", 16)
+	imgB := writeTestImage(t, tmpDir, "b.png", 16)
+
+	result, err := s.composeImages(context.Background(), map[string]any{
+		"image_paths": []string{imgA, imgB},
+		"prompt":      "combine these two images side by side",
+		"layout_hint": "side by side",
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, fake.calls)
+	require.Len(t, result.Content, 2)
+	require.Equal(t, "image", result.Content[1].Type)
+}
+
+func TestComposeImagesRejectsOversizedInput(t *testing.T) {
+	fake := &fakeGeminiModels{result: fakeGenerateContentResponse(onePixelPNGBase64)}
+	s, tmpDir := newCacheTestServer(t, fake)
+	s.composeMaxInputBytes = 20
+
+	imgA := writeTestImage(t, tmpDir, "a.png", 16)
+	imgB := writeTestImage(t, tmpDir, "b.png", 16)
+
+	_, err := s.composeImages(context.Background(), map[string]any{
+		"image_paths": []string{imgA, imgB},
+		"prompt":      "combine these two images",
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "exceed")
+	require.Contains(t, err.Error(), imgB)
+	require.Equal(t, 0, fake.calls, "oversized input should be rejected before calling the API")
+}
+
+func TestComposeImagesRejectsPathTraversal(t *testing.T) {
+	fake := &fakeGeminiModels{result: fakeGenerateContentResponse(onePixelPNGBase64)}
+	s, tmpDir := newCacheTestServer(t, fake)
+	imgA := writeTestImage(t, tmpDir, "a.png", 16)
+
+	testCases := []struct {
+		name string
+		path string
+	}{
+		{name: "relative parent", path: "../etc/passwd"},
+		{name: "multiple traversals", path: "../../secret.txt"},
+		{name: "traversal in middle", path: "foo/../../../bar"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := s.composeImages(context.Background(), map[string]any{
+				"image_paths": []string{imgA, tc.path},
+				"prompt":      "combine these two images",
+			})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "directory traversal not allowed")
+		})
+	}
+	require.Equal(t, 0, fake.calls, "path traversal should be rejected before calling the API")
+}
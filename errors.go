@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// ErrorCode identifies a class of tool failure, modeled on minio's
+// APIErrorCode table: a small closed enum that JSON-RPC clients can
+// branch on instead of string-matching error messages.
+type ErrorCode int
+
+const (
+	ErrInvalidArgument ErrorCode = iota + 1
+	ErrPathTraversal
+	ErrFileNotFound
+	ErrFileTooLarge
+	ErrUnsupportedMimeType
+	ErrUpstreamRateLimited
+	ErrUpstreamSafetyBlocked
+	ErrUpstreamTimeout
+	ErrUpstreamUnavailable
+	ErrNoImageReturned
+	ErrSaveFailed
+	ErrInternal
+)
+
+// errorCodeNames gives each ErrorCode the name a client sees in
+// Error.Data.code, e.g. "ErrUpstreamSafetyBlocked".
+var errorCodeNames = map[ErrorCode]string{
+	ErrInvalidArgument:       "ErrInvalidArgument",
+	ErrPathTraversal:         "ErrPathTraversal",
+	ErrFileNotFound:          "ErrFileNotFound",
+	ErrFileTooLarge:          "ErrFileTooLarge",
+	ErrUnsupportedMimeType:   "ErrUnsupportedMimeType",
+	ErrUpstreamRateLimited:   "ErrUpstreamRateLimited",
+	ErrUpstreamSafetyBlocked: "ErrUpstreamSafetyBlocked",
+	ErrUpstreamTimeout:       "ErrUpstreamTimeout",
+	ErrUpstreamUnavailable:   "ErrUpstreamUnavailable",
+	ErrNoImageReturned:       "ErrNoImageReturned",
+	ErrSaveFailed:            "ErrSaveFailed",
+	ErrInternal:              "ErrInternal",
+}
+
+func (c ErrorCode) String() string {
+	if name, ok := errorCodeNames[c]; ok {
+		return name
+	}
+	return "ErrInternal"
+}
+
+// errorTableEntry is the JSON-RPC code, human-readable message and
+// retry guidance associated with an ErrorCode.
+type errorTableEntry struct {
+	JSONRPCCode int
+	Message     string
+	Retryable   bool
+}
+
+// errorTable maps each ErrorCode to the response shape handleCallTool
+// should use for it. Codes describing a bad or oversized request use the
+// JSON-RPC "invalid params" code; upstream/internal failures use a
+// server-error code in the reserved range.
+var errorTable = map[ErrorCode]errorTableEntry{
+	ErrInvalidArgument:       {JSONRPCCode: -32602, Message: "invalid arguments", Retryable: false},
+	ErrPathTraversal:         {JSONRPCCode: -32602, Message: "path traversal is not allowed", Retryable: false},
+	ErrFileNotFound:          {JSONRPCCode: -32602, Message: "file not found", Retryable: false},
+	ErrFileTooLarge:          {JSONRPCCode: -32602, Message: "input exceeds the configured size limit", Retryable: false},
+	ErrUnsupportedMimeType:   {JSONRPCCode: -32602, Message: "unsupported mime type", Retryable: false},
+	ErrUpstreamRateLimited:   {JSONRPCCode: -32000, Message: "upstream rate limit exceeded", Retryable: true},
+	ErrUpstreamSafetyBlocked: {JSONRPCCode: -32001, Message: "upstream blocked the request on safety grounds", Retryable: false},
+	ErrUpstreamTimeout:       {JSONRPCCode: -32002, Message: "upstream request timed out", Retryable: true},
+	ErrUpstreamUnavailable:   {JSONRPCCode: -32003, Message: "upstream is unavailable", Retryable: true},
+	ErrNoImageReturned:       {JSONRPCCode: -32004, Message: "upstream returned no image", Retryable: true},
+	ErrSaveFailed:            {JSONRPCCode: -32005, Message: "failed to save output", Retryable: false},
+	ErrInternal:              {JSONRPCCode: -32603, Message: "internal error", Retryable: false},
+}
+
+// ToolError is the error type generateImage, editImage and composeImages
+// return for any failure that should reach the client as a classified
+// JSON-RPC error rather than a bare string. Cause, when set, is the
+// underlying error (e.g. from the genai client or the filesystem);
+// Details carries any extra fields worth surfacing in Error.Data, such
+// as the offending path.
+type ToolError struct {
+	Code    ErrorCode
+	Cause   error
+	Details map[string]any
+}
+
+func newToolError(code ErrorCode, cause error, details map[string]any) *ToolError {
+	return &ToolError{Code: code, Cause: cause, Details: details}
+}
+
+func (e *ToolError) Error() string {
+	entry := errorTable[e.Code]
+	if e.Cause != nil {
+		return entry.Message + ": " + e.Cause.Error()
+	}
+	return entry.Message
+}
+
+func (e *ToolError) Unwrap() error {
+	return e.Cause
+}
+
+// classifyGeminiError turns an error returned by geminiModels.GenerateContent
+// into a ToolError. The genai client doesn't export distinct error types for
+// these cases, so this falls back to matching the status text the API
+// returns, the same way callers of most JSON/HTTP-based SDKs have to.
+func classifyGeminiError(err error) *ToolError {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newToolError(ErrUpstreamTimeout, err, nil)
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "rate limit"), strings.Contains(msg, "quota"), strings.Contains(msg, "429"):
+		return newToolError(ErrUpstreamRateLimited, err, nil)
+	case strings.Contains(msg, "safety"), strings.Contains(msg, "blocked"):
+		return newToolError(ErrUpstreamSafetyBlocked, err, nil)
+	case strings.Contains(msg, "timeout"), strings.Contains(msg, "deadline"):
+		return newToolError(ErrUpstreamTimeout, err, nil)
+	case strings.Contains(msg, "unavailable"), strings.Contains(msg, "503"):
+		return newToolError(ErrUpstreamUnavailable, err, nil)
+	default:
+		return newToolError(ErrInternal, err, nil)
+	}
+}
+
+// candidateSafetyBlocked reports whether result's first candidate was cut
+// short by Gemini's safety filtering, as opposed to simply not containing
+// an image part.
+func candidateSafetyBlocked(result *genai.GenerateContentResponse) bool {
+	if len(result.Candidates) == 0 {
+		return false
+	}
+	switch result.Candidates[0].FinishReason {
+	case genai.FinishReasonSafety, genai.FinishReasonProhibitedContent:
+		return true
+	default:
+		return false
+	}
+}
+
+// noImageError builds the ToolError for a response that didn't contain an
+// image part, distinguishing a safety block from a generic empty result.
+func noImageError(result *genai.GenerateContentResponse, textResponse string) *ToolError {
+	details := map[string]any{}
+	if textResponse != "" {
+		details["modelResponse"] = textResponse
+	}
+	if candidateSafetyBlocked(result) {
+		return newToolError(ErrUpstreamSafetyBlocked, errors.New("candidate finish reason indicates a safety block"), details)
+	}
+	return newToolError(ErrNoImageReturned, errors.New("no image part in response"), details)
+}
+
+// wrapEncodeError classifies an encodeOutputFormat failure: format support
+// gaps (e.g. WebP encoding) are a client-facing ErrUnsupportedMimeType,
+// while a failure to even decode the generated PNG is our own bug.
+func wrapEncodeError(err error) *ToolError {
+	if strings.Contains(err.Error(), "not supported") || strings.Contains(err.Error(), "unsupported") {
+		return newToolError(ErrUnsupportedMimeType, err, nil)
+	}
+	return newToolError(ErrInternal, err, nil)
+}
+
+// acceptedInputMimeTypes are the image types the Gemini API accepts as
+// inline input data.
+var acceptedInputMimeTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/webp": true,
+}
+
+// validateInputMimeType rejects input images (for edit_image and
+// compose_images) whose mime type Gemini doesn't accept, such as GIFs.
+func validateInputMimeType(path, mimeType string) error {
+	if acceptedInputMimeTypes[mimeType] {
+		return nil
+	}
+	cause := fmt.Errorf("mime type %q is not supported for input images (path %q)", mimeType, path)
+	return newToolError(ErrUnsupportedMimeType, cause, map[string]any{"path": path, "mimeType": mimeType})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genai"
+)
+
+func TestClassifyGeminiError(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		code ErrorCode
+	}{
+		{"deadline exceeded", context.DeadlineExceeded, ErrUpstreamTimeout},
+		{"rate limit message", errors.New("429: Resource exhausted, rate limit hit"), ErrUpstreamRateLimited},
+		{"quota message", errors.New("quota exceeded for this project"), ErrUpstreamRateLimited},
+		{"safety message", errors.New("response blocked for safety reasons"), ErrUpstreamSafetyBlocked},
+		{"timeout message", errors.New("context deadline exceeded while waiting for response"), ErrUpstreamTimeout},
+		{"unavailable message", errors.New("503 Service Unavailable"), ErrUpstreamUnavailable},
+		{"unrecognized message", errors.New("something went sideways"), ErrInternal},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			toolErr := classifyGeminiError(tc.err)
+			require.Equal(t, tc.code, toolErr.Code)
+			require.ErrorIs(t, toolErr, tc.err)
+		})
+	}
+}
+
+func TestExtractImageAndTextHandlesEmptyCandidates(t *testing.T) {
+	result := &genai.GenerateContentResponse{Candidates: nil}
+
+	imageBytes, text := extractImageAndText(result)
+	require.Nil(t, imageBytes)
+	require.Empty(t, text)
+
+	toolErr := noImageError(result, text)
+	require.Equal(t, ErrNoImageReturned, toolErr.Code)
+}
+
+func TestGenerateImageHandlesEmptyCandidatesWithoutPanicking(t *testing.T) {
+	fake := &fakeGeminiModels{result: &genai.GenerateContentResponse{Candidates: nil}}
+	s, _ := newCacheTestServer(t, fake)
+
+	require.NotPanics(t, func() {
+		_, err := s.generateImage(context.Background(), map[string]any{
+			"prompt":     "a cat",
+			"cache_mode": "bypass",
+		})
+		require.Error(t, err)
+
+		var toolErr *ToolError
+		require.True(t, errors.As(err, &toolErr))
+		require.Equal(t, ErrNoImageReturned, toolErr.Code)
+	})
+}
+
+func TestNoImageErrorClassifiesSafetyBlock(t *testing.T) {
+	result := &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonSafety}},
+	}
+
+	toolErr := noImageError(result, "")
+	require.Equal(t, ErrUpstreamSafetyBlocked, toolErr.Code)
+}
+
+func TestNoImageErrorDefaultsToNoImageReturned(t *testing.T) {
+	result := &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{}},
+	}
+
+	toolErr := noImageError(result, "a model comment")
+	require.Equal(t, ErrNoImageReturned, toolErr.Code)
+	require.Equal(t, "a model comment", toolErr.Details["modelResponse"])
+}
+
+func TestToolErrorUnwrap(t *testing.T) {
+	cause := errors.New("boom")
+	toolErr := newToolError(ErrInternal, cause, nil)
+	require.ErrorIs(t, toolErr, cause)
+	require.Contains(t, toolErr.Error(), "boom")
+}
+
+func TestValidateInputMimeTypeRejectsGIF(t *testing.T) {
+	err := validateInputMimeType("anim.gif", "image/gif")
+	require.Error(t, err)
+
+	var toolErr *ToolError
+	require.True(t, errors.As(err, &toolErr))
+	require.Equal(t, ErrUnsupportedMimeType, toolErr.Code)
+}
+
+func TestValidateInputMimeTypeAcceptsPNGJPEGWebP(t *testing.T) {
+	for _, mimeType := range []string{"image/png", "image/jpeg", "image/webp"} {
+		require.NoError(t, validateInputMimeType("img", mimeType))
+	}
+}
+
+func TestGenerateImageClassifiesUpstreamError(t *testing.T) {
+	fake := &fakeGeminiModels{err: errors.New("429 rate limit exceeded")}
+	s, _ := newCacheTestServer(t, fake)
+
+	_, err := s.generateImage(context.Background(), map[string]any{
+		"prompt":     "a cat",
+		"cache_mode": "bypass",
+	})
+	require.Error(t, err)
+
+	var toolErr *ToolError
+	require.True(t, errors.As(err, &toolErr))
+	require.Equal(t, ErrUpstreamRateLimited, toolErr.Code)
+}
+
+func TestGenerateImageClassifiesNoImageReturned(t *testing.T) {
+	fake := &fakeGeminiModels{result: &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{Content: &genai.Content{Parts: []*genai.Part{{Text: "I can't do that."}}}}},
+	}}
+	s, _ := newCacheTestServer(t, fake)
+
+	_, err := s.generateImage(context.Background(), map[string]any{
+		"prompt":     "a cat",
+		"cache_mode": "bypass",
+	})
+	require.Error(t, err)
+
+	var toolErr *ToolError
+	require.True(t, errors.As(err, &toolErr))
+	require.Equal(t, ErrNoImageReturned, toolErr.Code)
+}
+
+func TestHandleCallToolSerializesToolErrorData(t *testing.T) {
+	s := newTestServer(t)
+	params, _ := json.Marshal(CallToolParams{
+		Name:      "generate_image",
+		Arguments: map[string]any{},
+	})
+	req := &JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      7,
+		Method:  "tools/call",
+		Params:  params,
+	}
+
+	resp := s.handleRequest(context.Background(), req)
+
+	require.NotNil(t, resp.Error)
+	data, ok := resp.Error.Data.(map[string]any)
+	require.True(t, ok)
+	require.Equal(t, "ErrInvalidArgument", data["code"])
+	require.Equal(t, false, data["retryable"])
+}
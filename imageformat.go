@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+)
+
+// encodeOutputFormat re-encodes the PNG bytes Gemini returns into the
+// requested output format, returning the encoded bytes and their MIME
+// type. An empty format means "png", i.e. no re-encode.
+func encodeOutputFormat(pngBytes []byte, format string) (encoded []byte, mimeType string, err error) {
+	switch format {
+	case "", "png":
+		return pngBytes, "image/png", nil
+	case "jpeg":
+		img, err := png.Decode(bytes.NewReader(pngBytes))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decode generated image: %w", err)
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+			return nil, "", fmt.Errorf("failed to encode JPEG: %w", err)
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	case "webp":
+		// golang.org/x/image/webp only implements a decoder; there is no
+		// maintained pure-Go WebP encoder, so this output format is not
+		// supported. The tool schemas do not list it and option validation
+		// rejects it before a request gets here.
+		return nil, "", fmt.Errorf("output_format %q is not supported yet: no Go WebP encoder is available", format)
+	default:
+		return nil, "", fmt.Errorf("unsupported output_format: %q", format)
+	}
+}
+
+// extensionForFormat returns the file extension to use for a saved image
+// in the given output format.
+func extensionForFormat(format string) string {
+	switch format {
+	case "jpeg":
+		return ".jpg"
+	case "webp":
+		return ".webp"
+	default:
+		return ".png"
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeOutputFormatPNGPassthrough(t *testing.T) {
+	pngBytes, err := base64.StdEncoding.DecodeString(onePixelPNGBase64)
+	require.NoError(t, err)
+
+	encoded, mimeType, err := encodeOutputFormat(pngBytes, "png")
+	require.NoError(t, err)
+	require.Equal(t, "image/png", mimeType)
+	require.Equal(t, pngBytes, encoded)
+}
+
+func TestEncodeOutputFormatJPEG(t *testing.T) {
+	pngBytes, err := base64.StdEncoding.DecodeString(onePixelPNGBase64)
+	require.NoError(t, err)
+
+	encoded, mimeType, err := encodeOutputFormat(pngBytes, "jpeg")
+	require.NoError(t, err)
+	require.Equal(t, "image/jpeg", mimeType)
+	require.NotEmpty(t, encoded)
+}
+
+func TestEncodeOutputFormatWebPUnsupported(t *testing.T) {
+	pngBytes, err := base64.StdEncoding.DecodeString(onePixelPNGBase64)
+	require.NoError(t, err)
+
+	_, _, err = encodeOutputFormat(pngBytes, "webp")
+	require.Error(t, err)
+}
+
+func TestExtensionForFormat(t *testing.T) {
+	require.Equal(t, ".png", extensionForFormat(""))
+	require.Equal(t, ".png", extensionForFormat("png"))
+	require.Equal(t, ".jpg", extensionForFormat("jpeg"))
+	require.Equal(t, ".webp", extensionForFormat("webp"))
+}
@@ -3,14 +3,17 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"google.golang.org/genai"
@@ -43,6 +46,7 @@ type JSONRPCResponse struct {
 type Error struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
 }
 
 // MCP types
@@ -58,7 +62,8 @@ type InitializeResult struct {
 }
 
 type Capabilities struct {
-	Tools *ToolsCapability `json:"tools,omitempty"`
+	Tools     *ToolsCapability     `json:"tools,omitempty"`
+	Resources *ResourcesCapability `json:"resources,omitempty"`
 }
 
 type ToolsCapability struct{}
@@ -76,8 +81,11 @@ type JSONSchema struct {
 }
 
 type Property struct {
-	Type        string `json:"type"`
-	Description string `json:"description"`
+	Type        string    `json:"type"`
+	Description string    `json:"description"`
+	Enum        []string  `json:"enum,omitempty"`
+	Items       *Property `json:"items,omitempty"`
+	MinItems    int       `json:"minItems,omitempty"`
 }
 
 type ListToolsResult struct {
@@ -101,20 +109,83 @@ type Content struct {
 	MimeType string `json:"mimeType,omitempty"`
 }
 
+// geminiModels is the subset of genai.Client.Models that the server
+// depends on, narrowed to an interface so tests can supply a fake.
+type geminiModels interface {
+	GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error)
+}
+
 // Server
 type Server struct {
-	client    *genai.Client
+	client    geminiModels
 	outputDir string
+
+	// cacheMaxEntries and cacheMaxBytes bound the on-disk generation
+	// cache; zero means unlimited.
+	cacheMaxEntries int
+	cacheMaxBytes   int64
+
+	// composeMaxInputBytes bounds the total size of the input images a
+	// single compose_images call may send to Gemini; zero means unlimited.
+	composeMaxInputBytes int64
+
+	// cacheMu guards the on-disk cache directory (lookupCache/writeCache/
+	// evictCache), since HTTPTransport dispatches concurrent calls into
+	// the same Server, unlike the original single-request-at-a-time
+	// StdioTransport.
+	cacheMu sync.Mutex
+
+	// mu guards sessions, resources and resourceSubs, which are written
+	// from request handlers and read when broadcasting notifications.
+	mu           sync.Mutex
+	sessions     map[string]*Session
+	resources    map[string]Resource
+	resourceSubs map[string]map[string]bool // sessionID -> subscribed resource URIs
 }
 
 func NewServer(client *genai.Client, outputDir string) *Server {
-	return &Server{
-		client:    client,
-		outputDir: outputDir,
+	s := &Server{
+		client:               client.Models,
+		outputDir:            outputDir,
+		cacheMaxEntries:      envInt("NANO_BANANA_CACHE_MAX_ENTRIES", 0),
+		cacheMaxBytes:        envInt64("NANO_BANANA_CACHE_MAX_BYTES", 0),
+		composeMaxInputBytes: envInt64("NANO_BANANA_COMPOSE_MAX_INPUT_BYTES", 20*1024*1024),
+		sessions:             make(map[string]*Session),
+		resources:            make(map[string]Resource),
+		resourceSubs:         make(map[string]map[string]bool),
+	}
+	s.scanOutputDir()
+	return s
+}
+
+// envInt reads an int from the named environment variable, falling back
+// to def if it is unset or not a valid integer.
+func envInt(name string, def int) int {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return def
 	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return n
 }
 
-func (s *Server) handleRequest(req *JSONRPCRequest) *JSONRPCResponse {
+// envInt64 is envInt for int64-sized limits such as byte counts.
+func envInt64(name string, def int64) int64 {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+func (s *Server) handleRequest(ctx context.Context, req *JSONRPCRequest) *JSONRPCResponse {
 	switch req.Method {
 	case "initialize":
 		return s.handleInitialize(req)
@@ -123,7 +194,13 @@ func (s *Server) handleRequest(req *JSONRPCRequest) *JSONRPCResponse {
 	case "tools/list":
 		return s.handleListTools(req)
 	case "tools/call":
-		return s.handleCallTool(req)
+		return s.handleCallTool(ctx, req)
+	case "resources/list":
+		return s.handleListResources(req)
+	case "resources/read":
+		return s.handleReadResource(req)
+	case "resources/subscribe":
+		return s.handleSubscribeResource(ctx, req)
 	default:
 		return &JSONRPCResponse{
 			JSONRPC: "2.0",
@@ -140,7 +217,8 @@ func (s *Server) handleInitialize(req *JSONRPCRequest) *JSONRPCResponse {
 		Result: InitializeResult{
 			ProtocolVersion: protocolVersion,
 			Capabilities: Capabilities{
-				Tools: &ToolsCapability{},
+				Tools:     &ToolsCapability{},
+				Resources: &ResourcesCapability{ListChanged: true, Subscribe: true},
 			},
 			ServerInfo: ServerInfo{
 				Name:    serverName,
@@ -155,34 +233,17 @@ func (s *Server) handleListTools(req *JSONRPCRequest) *JSONRPCResponse {
 		{
 			Name:        "generate_image",
 			Description: "Generate a new image from a text prompt using Google Gemini",
-			InputSchema: JSONSchema{
-				Type: "object",
-				Properties: map[string]Property{
-					"prompt": {
-						Type:        "string",
-						Description: "Text description of the image to generate",
-					},
-				},
-				Required: []string{"prompt"},
-			},
+			InputSchema: schemaForOptions(GenerateImageOptions{}),
 		},
 		{
 			Name:        "edit_image",
 			Description: "Edit an existing image using a text prompt",
-			InputSchema: JSONSchema{
-				Type: "object",
-				Properties: map[string]Property{
-					"image_path": {
-						Type:        "string",
-						Description: "Path to the image file to edit",
-					},
-					"prompt": {
-						Type:        "string",
-						Description: "Text description of the edits to make",
-					},
-				},
-				Required: []string{"image_path", "prompt"},
-			},
+			InputSchema: schemaForOptions(EditImageOptions{}),
+		},
+		{
+			Name:        "compose_images",
+			Description: "Combine 2-8 existing images into one new image using a text prompt",
+			InputSchema: schemaForOptions(ComposeImagesOptions{}),
 		},
 	}
 
@@ -193,7 +254,7 @@ func (s *Server) handleListTools(req *JSONRPCRequest) *JSONRPCResponse {
 	}
 }
 
-func (s *Server) handleCallTool(req *JSONRPCRequest) *JSONRPCResponse {
+func (s *Server) handleCallTool(ctx context.Context, req *JSONRPCRequest) *JSONRPCResponse {
 	var params CallToolParams
 	if err := json.Unmarshal(req.Params, &params); err != nil {
 		return &JSONRPCResponse{
@@ -208,9 +269,11 @@ func (s *Server) handleCallTool(req *JSONRPCRequest) *JSONRPCResponse {
 
 	switch params.Name {
 	case "generate_image":
-		result, err = s.generateImage(params.Arguments)
+		result, err = s.generateImage(ctx, params.Arguments)
 	case "edit_image":
-		result, err = s.editImage(params.Arguments)
+		result, err = s.editImage(ctx, params.Arguments)
+	case "compose_images":
+		result, err = s.composeImages(ctx, params.Arguments)
 	default:
 		return &JSONRPCResponse{
 			JSONRPC: "2.0",
@@ -223,10 +286,7 @@ func (s *Server) handleCallTool(req *JSONRPCRequest) *JSONRPCResponse {
 		return &JSONRPCResponse{
 			JSONRPC: "2.0",
 			ID:      req.ID,
-			Result: CallToolResult{
-				Content: []Content{{Type: "text", Text: "Error: " + err.Error()}},
-				IsError: true,
-			},
+			Error:   toolErrorResponse(err),
 		}
 	}
 
@@ -237,143 +297,415 @@ func (s *Server) handleCallTool(req *JSONRPCRequest) *JSONRPCResponse {
 	}
 }
 
-func (s *Server) generateImage(args map[string]any) (CallToolResult, error) {
-	prompt, ok := args["prompt"].(string)
-	if !ok || prompt == "" {
-		return CallToolResult{}, fmt.Errorf("prompt is required")
+// toolErrorResponse builds the JSON-RPC Error for a tool call failure. A
+// *ToolError carries its own classification and is serialized into
+// Error.Data as {code, retryable, details} so clients can branch on the
+// code; any other error (e.g. an argument-parsing error that predates the
+// ToolError taxonomy) falls back to a generic invalid-argument shape.
+func toolErrorResponse(err error) *Error {
+	var toolErr *ToolError
+	if !errors.As(err, &toolErr) {
+		toolErr = newToolError(ErrInvalidArgument, err, nil)
+	}
+
+	entry := errorTable[toolErr.Code]
+	return &Error{
+		Code:    entry.JSONRPCCode,
+		Message: toolErr.Error(),
+		Data: map[string]any{
+			"code":      toolErr.Code.String(),
+			"retryable": entry.Retryable,
+			"details":   toolErr.Details,
+		},
 	}
+}
 
-	ctx := context.Background()
-	result, err := s.client.Models.GenerateContent(ctx, geminiModel, genai.Text(prompt), nil)
+func (s *Server) generateImage(ctx context.Context, args map[string]any) (CallToolResult, error) {
+	opts, err := parseGenerateImageOptions(args)
 	if err != nil {
-		return CallToolResult{}, fmt.Errorf("API request failed: %w", err)
+		return CallToolResult{}, newToolError(ErrInvalidArgument, err, nil)
 	}
 
-	var imageData, textResponse string
-	for _, part := range result.Candidates[0].Content.Parts {
-		if part.Text != "" {
-			textResponse += part.Text
-		} else if part.InlineData != nil {
-			imageData = base64.StdEncoding.EncodeToString(part.InlineData.Data)
+	promptText := buildPromptText(opts.Prompt, opts.NegativePrompt, opts.AspectRatio, opts.Seed)
+	digest := cacheDigest(geminiModel, promptText, "", nil)
+
+	// A cache entry only ever holds one image, so a hit can't satisfy a
+	// number_of_images > 1 request; bypass the lookup (the generation
+	// loop below still writes/refreshes the single cache entry from i==0).
+	if opts.NumberOfImages == 1 && opts.CacheMode != CacheModeBypass && opts.CacheMode != CacheModeRefresh {
+		if content, hit, err := s.cachedContent(digest, opts.OutputFormat, fmt.Sprintf("cache: hit\n\nPrompt: %s", opts.Prompt)); err != nil {
+			return CallToolResult{}, err
+		} else if hit {
+			return CallToolResult{Content: content}, nil
 		}
 	}
 
-	if imageData == "" {
-		msg := "No image was generated."
-		if textResponse != "" {
-			msg += "\n\nModel response: " + textResponse
+	var content []Content
+	for i := 0; i < opts.NumberOfImages; i++ {
+		notifyProgress(ctx, i, opts.NumberOfImages, fmt.Sprintf("generating image %d/%d", i+1, opts.NumberOfImages))
+
+		result, err := s.client.GenerateContent(ctx, geminiModel, genai.Text(promptText), nil)
+		if err != nil {
+			return CallToolResult{}, classifyGeminiError(err)
+		}
+
+		pngData, textResponse := extractImageAndText(result)
+		if pngData == nil {
+			return CallToolResult{}, noImageError(result, textResponse)
+		}
+
+		outputBytes, mimeType, err := encodeOutputFormat(pngData, opts.OutputFormat)
+		if err != nil {
+			return CallToolResult{}, wrapEncodeError(err)
+		}
+
+		filePath, err := s.saveImage(outputBytes, "generated", opts.OutputFormat, opts.SaveOptions, filenameData{
+			Prompt:    opts.Prompt,
+			Timestamp: time.Now().Format("2006-01-02T15-04-05"),
+			Index:     i,
+			Hash:      digest,
+		})
+		if err != nil {
+			return CallToolResult{}, newToolError(ErrSaveFailed, err, nil)
+		}
+
+		if i == 0 && opts.CacheMode != CacheModeBypass {
+			s.cacheGeneratedImage(digest, pngData, opts.Prompt, "")
 		}
-		return CallToolResult{
-			Content: []Content{{Type: "text", Text: msg}},
-		}, nil
+		s.registerGeneratedResource(filePath, opts.Prompt, time.Now())
+
+		content = append(content,
+			Content{Type: "text", Text: fmt.Sprintf("Image generated and saved to: %s\n\nPrompt: %s", filePath, opts.Prompt)},
+			Content{Type: "image", Data: base64.StdEncoding.EncodeToString(outputBytes), MimeType: mimeType},
+		)
+	}
+	notifyProgress(ctx, opts.NumberOfImages, opts.NumberOfImages, "done")
+	if len(content) > 0 {
+		s.broadcastResourcesChanged()
+	}
+
+	return CallToolResult{Content: content}, nil
+}
+
+// cachedContent resolves a cache hit for digest into the Content entries
+// a tool call should return, re-encoding the cached PNG into the
+// requested output format. ok is false on a miss.
+func (s *Server) cachedContent(digest, outputFormat, text string) (content []Content, ok bool, err error) {
+	imageB64, _, hit := s.lookupCache(digest)
+	if !hit {
+		return nil, false, nil
 	}
 
-	filePath, err := s.saveImage(imageData, "generated")
+	pngBytes, err := base64.StdEncoding.DecodeString(imageB64)
 	if err != nil {
-		return CallToolResult{}, fmt.Errorf("failed to save image: %w", err)
+		return nil, false, newToolError(ErrInternal, fmt.Errorf("failed to decode cached image: %w", err), nil)
 	}
 
-	content := []Content{
-		{Type: "text", Text: fmt.Sprintf("Image generated and saved to: %s\n\nPrompt: %s", filePath, prompt)},
-		{Type: "image", Data: imageData, MimeType: "image/png"},
+	outputBytes, mimeType, err := encodeOutputFormat(pngBytes, outputFormat)
+	if err != nil {
+		return nil, false, wrapEncodeError(err)
 	}
 
-	return CallToolResult{Content: content}, nil
+	return []Content{
+		{Type: "text", Text: text},
+		{Type: "image", Data: base64.StdEncoding.EncodeToString(outputBytes), MimeType: mimeType},
+	}, true, nil
 }
 
-func (s *Server) editImage(args map[string]any) (CallToolResult, error) {
-	imagePath, ok := args["image_path"].(string)
-	if !ok || imagePath == "" {
-		return CallToolResult{}, fmt.Errorf("image_path is required")
+// extractImageAndText pulls the generated image bytes and any
+// accompanying text out of a Gemini response's first candidate. A
+// fully prompt-blocked response can come back with no candidates at
+// all, and a safety-blocked candidate can come back with no content, so
+// both report no image/text rather than indexing blindly;
+// callers already treat a nil imageBytes as "no image returned" and
+// classify it (see noImageError), which also covers the safety-block case.
+func extractImageAndText(result *genai.GenerateContentResponse) (imageBytes []byte, text string) {
+	if len(result.Candidates) == 0 || result.Candidates[0].Content == nil {
+		return nil, ""
 	}
+	for _, part := range result.Candidates[0].Content.Parts {
+		if part.Text != "" {
+			text += part.Text
+		} else if part.InlineData != nil {
+			imageBytes = part.InlineData.Data
+		}
+	}
+	return imageBytes, text
+}
 
-	prompt, ok := args["prompt"].(string)
-	if !ok || prompt == "" {
-		return CallToolResult{}, fmt.Errorf("prompt is required")
+// cacheGeneratedImage writes a successful generation to the cache,
+// swallowing write failures since eviction/caching must never fail a
+// generate/edit call that otherwise succeeded.
+func (s *Server) cacheGeneratedImage(digest string, pngBytes []byte, prompt, inputHash string) {
+	if err := s.writeCache(digest, pngBytes, cacheSidecar{
+		Prompt:    prompt,
+		Model:     geminiModel,
+		InputHash: inputHash,
+		CreatedAt: time.Now(),
+		MimeType:  "image/png",
+	}); err != nil {
+		return
+	}
+	s.refreshCacheResource(digest)
+}
+
+func (s *Server) editImage(ctx context.Context, args map[string]any) (CallToolResult, error) {
+	opts, err := parseEditImageOptions(args)
+	if err != nil {
+		return CallToolResult{}, newToolError(ErrInvalidArgument, err, nil)
 	}
 
-	cleanPath := filepath.Clean(imagePath)
+	cleanPath := filepath.Clean(opts.ImagePath)
 	if strings.Contains(cleanPath, "..") {
-		return CallToolResult{}, fmt.Errorf("invalid image path: directory traversal not allowed")
+		return CallToolResult{}, newToolError(ErrPathTraversal, fmt.Errorf("invalid image path: directory traversal not allowed"), map[string]any{"path": opts.ImagePath})
 	}
 
 	imageBytes, err := os.ReadFile(cleanPath)
 	if err != nil {
-		return CallToolResult{}, fmt.Errorf("failed to read image: %w", err)
+		return CallToolResult{}, newToolError(ErrFileNotFound, fmt.Errorf("failed to read image: %w", err), map[string]any{"path": opts.ImagePath})
 	}
 
-	ctx := context.Background()
-	result, err := s.client.Models.GenerateContent(ctx, geminiModel,
-		[]*genai.Content{{
-			Parts: []*genai.Part{
-				{InlineData: &genai.Blob{MIMEType: getMimeType(cleanPath), Data: imageBytes}},
-				{Text: prompt},
-			},
-		}},
-		nil,
-	)
+	inputMimeType := getMimeType(cleanPath)
+	if err := validateInputMimeType(opts.ImagePath, inputMimeType); err != nil {
+		return CallToolResult{}, err
+	}
+
+	promptText := buildPromptText(opts.Prompt, opts.NegativePrompt, opts.AspectRatio, opts.Seed)
+	digest := cacheDigest(geminiModel, promptText, inputMimeType, imageBytes)
+
+	// See generateImage: a cache entry only ever holds one image.
+	if opts.NumberOfImages == 1 && opts.CacheMode != CacheModeBypass && opts.CacheMode != CacheModeRefresh {
+		text := fmt.Sprintf("cache: hit\n\nOriginal: %s\nPrompt: %s", opts.ImagePath, opts.Prompt)
+		if content, hit, err := s.cachedContent(digest, opts.OutputFormat, text); err != nil {
+			return CallToolResult{}, err
+		} else if hit {
+			return CallToolResult{Content: content}, nil
+		}
+	}
+
+	var content []Content
+	for i := 0; i < opts.NumberOfImages; i++ {
+		notifyProgress(ctx, i, opts.NumberOfImages, fmt.Sprintf("editing image %d/%d", i+1, opts.NumberOfImages))
+		result, err := s.client.GenerateContent(ctx, geminiModel,
+			[]*genai.Content{{
+				Parts: []*genai.Part{
+					{InlineData: &genai.Blob{MIMEType: inputMimeType, Data: imageBytes}},
+					{Text: promptText},
+				},
+			}},
+			nil,
+		)
+		if err != nil {
+			return CallToolResult{}, classifyGeminiError(err)
+		}
+
+		pngData, textResponse := extractImageAndText(result)
+		if pngData == nil {
+			return CallToolResult{}, noImageError(result, textResponse)
+		}
+
+		outputBytes, mimeType, err := encodeOutputFormat(pngData, opts.OutputFormat)
+		if err != nil {
+			return CallToolResult{}, wrapEncodeError(err)
+		}
+
+		filePath, err := s.saveImage(outputBytes, "edited", opts.OutputFormat, opts.SaveOptions, filenameData{
+			Prompt:    opts.Prompt,
+			Timestamp: time.Now().Format("2006-01-02T15-04-05"),
+			Index:     i,
+			Hash:      digest,
+		})
+		if err != nil {
+			return CallToolResult{}, newToolError(ErrSaveFailed, err, nil)
+		}
+
+		if i == 0 && opts.CacheMode != CacheModeBypass {
+			s.cacheGeneratedImage(digest, pngData, opts.Prompt, hashBytes(imageBytes))
+		}
+		s.registerGeneratedResource(filePath, opts.Prompt, time.Now())
+
+		content = append(content,
+			Content{Type: "text", Text: fmt.Sprintf("Image edited and saved to: %s\n\nOriginal: %s\nPrompt: %s", filePath, opts.ImagePath, opts.Prompt)},
+			Content{Type: "image", Data: base64.StdEncoding.EncodeToString(outputBytes), MimeType: mimeType},
+		)
+	}
+
+	notifyProgress(ctx, opts.NumberOfImages, opts.NumberOfImages, "done")
+	if len(content) > 0 {
+		s.broadcastResourcesChanged()
+	}
+	return CallToolResult{Content: content}, nil
+}
+
+// composeImages combines several existing images into one, guided by a
+// single prompt. Unlike generateImage/editImage it always sends exactly
+// one genai.Content with one InlineData part per input image, in the
+// order given, followed by the prompt text.
+func (s *Server) composeImages(ctx context.Context, args map[string]any) (CallToolResult, error) {
+	opts, err := parseComposeImagesOptions(args)
 	if err != nil {
-		return CallToolResult{}, fmt.Errorf("API request failed: %w", err)
+		return CallToolResult{}, newToolError(ErrInvalidArgument, err, nil)
 	}
 
-	var imageData, textResponse string
-	for _, part := range result.Candidates[0].Content.Parts {
-		if part.Text != "" {
-			textResponse += part.Text
-		} else if part.InlineData != nil {
-			imageData = base64.StdEncoding.EncodeToString(part.InlineData.Data)
+	type inputImage struct {
+		mimeType string
+		bytes    []byte
+	}
+
+	inputs := make([]inputImage, 0, len(opts.ImagePaths))
+	var totalBytes int64
+	for _, p := range opts.ImagePaths {
+		cleanPath := filepath.Clean(p)
+		if strings.Contains(cleanPath, "..") {
+			return CallToolResult{}, newToolError(ErrPathTraversal, fmt.Errorf("invalid image path %q: directory traversal not allowed", p), map[string]any{"path": p})
+		}
+
+		data, err := os.ReadFile(cleanPath)
+		if err != nil {
+			return CallToolResult{}, newToolError(ErrFileNotFound, fmt.Errorf("failed to read image %q: %w", p, err), map[string]any{"path": p})
+		}
+
+		totalBytes += int64(len(data))
+		if s.composeMaxInputBytes > 0 && totalBytes > s.composeMaxInputBytes {
+			return CallToolResult{}, newToolError(ErrFileTooLarge, fmt.Errorf("input images exceed the %d byte limit (stopped at %q)", s.composeMaxInputBytes, p), map[string]any{"path": p, "limitBytes": s.composeMaxInputBytes})
+		}
+
+		mimeType := getMimeType(cleanPath)
+		if err := validateInputMimeType(p, mimeType); err != nil {
+			return CallToolResult{}, err
 		}
+
+		inputs = append(inputs, inputImage{mimeType: mimeType, bytes: data})
+	}
+
+	promptText := buildPromptText(opts.Prompt, opts.NegativePrompt, opts.AspectRatio, opts.Seed)
+	if opts.LayoutHint != "" {
+		promptText += fmt.Sprintf("\n\nLayout: %s", opts.LayoutHint)
+	}
+
+	inputBytes := make([][]byte, len(inputs))
+	for i, in := range inputs {
+		inputBytes[i] = in.bytes
 	}
+	digest := composeCacheDigest(geminiModel, promptText, inputBytes)
 
-	if imageData == "" {
-		msg := "No edited image was generated."
-		if textResponse != "" {
-			msg += "\n\nModel response: " + textResponse
+	if opts.CacheMode != CacheModeBypass && opts.CacheMode != CacheModeRefresh {
+		text := fmt.Sprintf("cache: hit\n\nPrompt: %s", opts.Prompt)
+		if content, hit, err := s.cachedContent(digest, opts.OutputFormat, text); err != nil {
+			return CallToolResult{}, err
+		} else if hit {
+			return CallToolResult{Content: content}, nil
 		}
-		return CallToolResult{
-			Content: []Content{{Type: "text", Text: msg}},
-		}, nil
 	}
 
-	filePath, err := s.saveImage(imageData, "edited")
+	notifyProgress(ctx, 0, 1, "composing image")
+
+	parts := make([]*genai.Part, 0, len(inputs)+1)
+	for _, in := range inputs {
+		parts = append(parts, &genai.Part{InlineData: &genai.Blob{MIMEType: in.mimeType, Data: in.bytes}})
+	}
+	parts = append(parts, &genai.Part{Text: promptText})
+
+	result, err := s.client.GenerateContent(ctx, geminiModel, []*genai.Content{{Parts: parts}}, nil)
 	if err != nil {
-		return CallToolResult{}, fmt.Errorf("failed to save image: %w", err)
+		return CallToolResult{}, classifyGeminiError(err)
 	}
 
-	content := []Content{
-		{Type: "text", Text: fmt.Sprintf("Image edited and saved to: %s\n\nOriginal: %s\nPrompt: %s", filePath, imagePath, prompt)},
-		{Type: "image", Data: imageData, MimeType: "image/png"},
+	pngData, textResponse := extractImageAndText(result)
+	if pngData == nil {
+		return CallToolResult{}, noImageError(result, textResponse)
 	}
 
-	return CallToolResult{Content: content}, nil
-}
+	outputBytes, mimeType, err := encodeOutputFormat(pngData, opts.OutputFormat)
+	if err != nil {
+		return CallToolResult{}, wrapEncodeError(err)
+	}
 
-func (s *Server) saveImage(base64Data, prefix string) (string, error) {
-	dir := filepath.Join(".", s.outputDir)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return "", err
+	filePath, err := s.saveImage(outputBytes, "composed", opts.OutputFormat, opts.SaveOptions, filenameData{
+		Prompt:    opts.Prompt,
+		Timestamp: time.Now().Format("2006-01-02T15-04-05"),
+		Hash:      digest,
+	})
+	if err != nil {
+		return CallToolResult{}, newToolError(ErrSaveFailed, err, nil)
 	}
 
-	timestamp := time.Now().Format("2006-01-02T15-04-05")
-	filename := fmt.Sprintf("%s-%s.png", prefix, timestamp)
-	filePath := filepath.Join(dir, filename)
+	if opts.CacheMode != CacheModeBypass {
+		s.cacheGeneratedImage(digest, pngData, opts.Prompt, "")
+	}
+	s.registerGeneratedResource(filePath, opts.Prompt, time.Now())
+
+	notifyProgress(ctx, 1, 1, "done")
+	s.broadcastResourcesChanged()
 
-	imageBytes, err := base64.StdEncoding.DecodeString(base64Data)
+	return CallToolResult{Content: []Content{
+		{Type: "text", Text: fmt.Sprintf("Images composed and saved to: %s\n\nPrompt: %s", filePath, opts.Prompt)},
+		{Type: "image", Data: base64.StdEncoding.EncodeToString(outputBytes), MimeType: mimeType},
+	}}, nil
+}
+
+// saveImage writes imageBytes under the server's output directory (or
+// save.OutputDirOverride, if set) using a filename derived from
+// save.FilenameTemplate, or the repo's default "<prefix>-<timestamp>"
+// pattern when no template is given.
+func (s *Server) saveImage(imageBytes []byte, prefix, format string, save SaveOptions, data filenameData) (string, error) {
+	dir, err := s.resolveOutputDir(save.OutputDirOverride)
 	if err != nil {
 		return "", err
 	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	dirAbs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
 
-	if err := os.WriteFile(filePath, imageBytes, 0644); err != nil {
+	filename, err := renderFilename(save.FilenameTemplate, prefix, extensionForFormat(format), data)
+	if err != nil {
 		return "", err
 	}
 
+	filePath := filepath.Join(dir, filename)
 	absPath, err := filepath.Abs(filePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to get absolute path: %w", err)
 	}
+	if absPath != dirAbs && !strings.HasPrefix(absPath, dirAbs+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid filename_template: directory traversal not allowed")
+	}
+
+	if err := os.WriteFile(absPath, imageBytes, 0644); err != nil {
+		return "", err
+	}
 	return absPath, nil
 }
 
+// resolveOutputDir resolves override (SaveOptions.OutputDirOverride) to a
+// directory to write into, or s.outputDir when override is blank. override
+// is untrusted tool input, so it's resolved against the process's working
+// directory and rejected if the result would land outside it — otherwise a
+// value like "../../../../tmp/pwned" would let a caller write anywhere the
+// process has permissions, the same class of traversal filename_template is
+// guarded against in saveImage.
+func (s *Server) resolveOutputDir(override string) (string, error) {
+	if override == "" {
+		return filepath.Join(".", s.outputDir), nil
+	}
+
+	root, err := filepath.Abs(".")
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	dirAbs := filepath.Join(root, override)
+	if dirAbs != root && !strings.HasPrefix(dirAbs, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid output_dir_override: directory traversal not allowed")
+	}
+
+	return filepath.Join(".", override), nil
+}
+
 func getMimeType(path string) string {
 	ext := strings.ToLower(filepath.Ext(path))
 	switch ext {
@@ -390,38 +722,16 @@ func getMimeType(path string) string {
 	}
 }
 
+// Run serves the server over stdio, the server's original transport.
 func (s *Server) Run() error {
-	scanner := bufio.NewScanner(os.Stdin)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-
-		var req JSONRPCRequest
-		if err := json.Unmarshal([]byte(line), &req); err != nil {
-			continue
-		}
-
-		resp := s.handleRequest(&req)
-		if resp == nil {
-			continue
-		}
-
-		respJSON, err := json.Marshal(resp)
-		if err != nil {
-			continue
-		}
-
-		fmt.Println(string(respJSON))
-		_ = os.Stdout.Sync()
-	}
-
-	return scanner.Err()
+	return StdioTransport{}.Serve(context.Background(), s)
 }
 
 func main() {
+	outputDir := flag.String("output-dir", defaultOutputDir, "directory to write generated images to")
+	httpAddr := flag.String("http-addr", "", "if set, serve over HTTP+SSE on this address instead of stdio (e.g. :8080)")
+	flag.Parse()
+
 	ctx := context.Background()
 	client, err := genai.NewClient(ctx, nil)
 	if err != nil {
@@ -429,13 +739,16 @@ func main() {
 		os.Exit(1)
 	}
 
-	outputDir := defaultOutputDir
-	if len(os.Args) > 1 {
-		outputDir = os.Args[1]
+	server := NewServer(client, *outputDir)
+
+	var transport Transport
+	if *httpAddr != "" {
+		transport = NewHTTPTransport(*httpAddr, os.Getenv("MCP_AUTH_TOKEN"))
+	} else {
+		transport = StdioTransport{}
 	}
 
-	server := NewServer(client, outputDir)
-	if err := server.Run(); err != nil {
+	if err := transport.Serve(ctx, server); err != nil {
 		fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
 		os.Exit(1)
 	}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -33,7 +36,7 @@ func TestHandleInitialize(t *testing.T) {
 		Method:  "initialize",
 	}
 
-	resp := s.handleRequest(req)
+	resp := s.handleRequest(context.Background(), req)
 
 	require.NotNil(t, resp)
 	require.Equal(t, "2.0", resp.JSONRPC)
@@ -56,14 +59,14 @@ func TestHandleListTools(t *testing.T) {
 		Method:  "tools/list",
 	}
 
-	resp := s.handleRequest(req)
+	resp := s.handleRequest(context.Background(), req)
 
 	require.NotNil(t, resp)
 	require.Nil(t, resp.Error)
 
 	result, ok := resp.Result.(ListToolsResult)
 	require.True(t, ok)
-	require.Len(t, result.Tools, 2)
+	require.Len(t, result.Tools, 3)
 
 	toolNames := make([]string, len(result.Tools))
 	for i, tool := range result.Tools {
@@ -71,6 +74,7 @@ func TestHandleListTools(t *testing.T) {
 	}
 	require.Contains(t, toolNames, "generate_image")
 	require.Contains(t, toolNames, "edit_image")
+	require.Contains(t, toolNames, "compose_images")
 }
 
 func TestHandleCallToolUnknown(t *testing.T) {
@@ -86,7 +90,7 @@ func TestHandleCallToolUnknown(t *testing.T) {
 		Params:  params,
 	}
 
-	resp := s.handleRequest(req)
+	resp := s.handleRequest(context.Background(), req)
 
 	require.NotNil(t, resp)
 	require.NotNil(t, resp.Error)
@@ -102,7 +106,7 @@ func TestHandleUnknownMethod(t *testing.T) {
 		Method:  "unknown/method",
 	}
 
-	resp := s.handleRequest(req)
+	resp := s.handleRequest(context.Background(), req)
 
 	require.NotNil(t, resp)
 	require.NotNil(t, resp.Error)
@@ -118,7 +122,7 @@ func TestHandleNotification(t *testing.T) {
 		Method:  "notifications/initialized",
 	}
 
-	resp := s.handleRequest(req)
+	resp := s.handleRequest(context.Background(), req)
 
 	require.Nil(t, resp, "notifications should not return a response")
 }
@@ -127,32 +131,37 @@ func TestGenerateImageMissingPrompt(t *testing.T) {
 	s := newTestServer(t)
 
 	testCases := []struct {
-		name string
-		args map[string]any
+		name        string
+		args        map[string]any
+		expectedErr string
 	}{
 		{
-			name: "nil args",
-			args: nil,
+			name:        "nil args",
+			args:        nil,
+			expectedErr: "prompt is required",
 		},
 		{
-			name: "empty args",
-			args: map[string]any{},
+			name:        "empty args",
+			args:        map[string]any{},
+			expectedErr: "prompt is required",
 		},
 		{
-			name: "empty prompt",
-			args: map[string]any{"prompt": ""},
+			name:        "empty prompt",
+			args:        map[string]any{"prompt": ""},
+			expectedErr: "prompt is required",
 		},
 		{
-			name: "wrong type",
-			args: map[string]any{"prompt": 123},
+			name:        "wrong type",
+			args:        map[string]any{"prompt": 123},
+			expectedErr: "invalid arguments",
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			_, err := s.generateImage(tc.args)
+			_, err := s.generateImage(context.Background(), tc.args)
 			require.Error(t, err)
-			require.Contains(t, err.Error(), "prompt is required")
+			require.Contains(t, err.Error(), tc.expectedErr)
 		})
 	}
 }
@@ -189,7 +198,7 @@ func TestEditImageMissingParams(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			_, err := s.editImage(tc.args)
+			_, err := s.editImage(context.Background(), tc.args)
 			require.Error(t, err)
 			require.Contains(t, err.Error(), tc.expectedErr)
 		})
@@ -220,7 +229,7 @@ func TestEditImagePathTraversal(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			_, err := s.editImage(map[string]any{
+			_, err := s.editImage(context.Background(), map[string]any{
 				"image_path": tc.path,
 				"prompt":     "test prompt",
 			})
@@ -233,7 +242,7 @@ func TestEditImagePathTraversal(t *testing.T) {
 func TestEditImageFileNotFound(t *testing.T) {
 	s := newTestServer(t)
 
-	_, err := s.editImage(map[string]any{
+	_, err := s.editImage(context.Background(), map[string]any{
 		"image_path": "/nonexistent/image.png",
 		"prompt":     "test prompt",
 	})
@@ -279,10 +288,11 @@ func TestSaveImage(t *testing.T) {
 		_ = os.Chdir(originalDir)
 	})
 
-	// Valid base64 PNG (1x1 red pixel)
-	pngBase64 := "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8z8DwHwAFBQIAX8jx0gAAAABJRU5ErkJggg=="
+	// 1x1 red pixel PNG
+	pngBytes, err := base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8z8DwHwAFBQIAX8jx0gAAAABJRU5ErkJggg==")
+	require.NoError(t, err)
 
-	path, err := s.saveImage(pngBase64, "test")
+	path, err := s.saveImage(pngBytes, "test", "png", SaveOptions{}, filenameData{Timestamp: "2026-01-01T00-00-00"})
 	require.NoError(t, err)
 	require.NotEmpty(t, path)
 	require.Contains(t, path, "test-")
@@ -294,7 +304,7 @@ func TestSaveImage(t *testing.T) {
 	require.Greater(t, info.Size(), int64(0))
 }
 
-func TestSaveImageInvalidBase64(t *testing.T) {
+func TestSaveImageInvalidFilenameTemplate(t *testing.T) {
 	s := newTestServer(t)
 
 	tmpDir := t.TempDir()
@@ -307,10 +317,63 @@ func TestSaveImageInvalidBase64(t *testing.T) {
 		_ = os.Chdir(originalDir)
 	})
 
-	_, err = s.saveImage("not-valid-base64!!!", "test")
+	_, err = s.saveImage([]byte("not a real png"), "test", "png", SaveOptions{FilenameTemplate: "{{.Nonexistent"}, filenameData{})
 	require.Error(t, err)
 }
 
+func TestSaveImageRejectsFilenameTemplateTraversal(t *testing.T) {
+	s := newTestServer(t)
+
+	tmpDir := t.TempDir()
+	originalDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tmpDir))
+	t.Cleanup(func() { _ = os.Chdir(originalDir) })
+
+	_, err = s.saveImage([]byte("fake png bytes"), "test", "png", SaveOptions{FilenameTemplate: "../../escaped"}, filenameData{Timestamp: "2026-01-01T00-00-00"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "directory traversal not allowed")
+
+	_, statErr := os.Stat(filepath.Join(tmpDir, "..", "..", "escaped.png"))
+	require.True(t, os.IsNotExist(statErr), "file must not have been written outside the output directory")
+}
+
+func TestSaveImageRejectsOutputDirOverrideTraversal(t *testing.T) {
+	s := newTestServer(t)
+
+	tmpDir := t.TempDir()
+	originalDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tmpDir))
+	t.Cleanup(func() { _ = os.Chdir(originalDir) })
+
+	_, err = s.saveImage([]byte("fake png bytes"), "test", "png", SaveOptions{OutputDirOverride: "../../../../tmp/pwned"}, filenameData{Timestamp: "2026-01-01T00-00-00"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "directory traversal not allowed")
+
+	escaped := filepath.Join(tmpDir, "../../../../tmp/pwned")
+	_, statErr := os.Stat(escaped)
+	require.True(t, os.IsNotExist(statErr), "directory must not have been created outside the output root")
+}
+
+func TestSaveImageAllowsOutputDirOverrideWithinRoot(t *testing.T) {
+	s := newTestServer(t)
+
+	tmpDir := t.TempDir()
+	originalDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tmpDir))
+	t.Cleanup(func() { _ = os.Chdir(originalDir) })
+
+	path, err := s.saveImage([]byte("fake png bytes"), "test", "png", SaveOptions{OutputDirOverride: "subdir"}, filenameData{Timestamp: "2026-01-01T00-00-00"})
+	require.NoError(t, err)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Greater(t, info.Size(), int64(0))
+	require.True(t, strings.HasPrefix(path, filepath.Join(tmpDir, "subdir")))
+}
+
 func TestHandleCallToolInvalidParams(t *testing.T) {
 	s := newTestServer(t)
 	req := &JSONRPCRequest{
@@ -320,7 +383,7 @@ func TestHandleCallToolInvalidParams(t *testing.T) {
 		Params:  json.RawMessage(`{"invalid json`),
 	}
 
-	resp := s.handleRequest(req)
+	resp := s.handleRequest(context.Background(), req)
 
 	require.NotNil(t, resp)
 	require.NotNil(t, resp.Error)
@@ -346,8 +409,9 @@ func TestOutputDirCreation(t *testing.T) {
 	require.True(t, os.IsNotExist(err))
 
 	// Save image should create the directory
-	pngBase64 := "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8z8DwHwAFBQIAX8jx0gAAAABJRU5ErkJggg=="
-	_, err = s.saveImage(pngBase64, "test")
+	pngBytes, err := base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8z8DwHwAFBQIAX8jx0gAAAABJRU5ErkJggg==")
+	require.NoError(t, err)
+	_, err = s.saveImage(pngBytes, "test", "png", SaveOptions{}, filenameData{Timestamp: "2026-01-01T00-00-00"})
 	require.NoError(t, err)
 
 	// Verify directory was created
@@ -371,8 +435,9 @@ func TestCustomOutputDir(t *testing.T) {
 	})
 
 	// Save image should create the custom directory
-	pngBase64 := "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8z8DwHwAFBQIAX8jx0gAAAABJRU5ErkJggg=="
-	path, err := s.saveImage(pngBase64, "test")
+	pngBytes, err := base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8z8DwHwAFBQIAX8jx0gAAAABJRU5ErkJggg==")
+	require.NoError(t, err)
+	path, err := s.saveImage(pngBytes, "test", "png", SaveOptions{}, filenameData{Timestamp: "2026-01-01T00-00-00"})
 	require.NoError(t, err)
 	require.Contains(t, path, customDir)
 
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"text/template"
+)
+
+// SaveOptions controls where and under what name a generated image is
+// written, shared by every tool that produces an image.
+type SaveOptions struct {
+	OutputDirOverride string `json:"output_dir_override,omitempty" desc:"Write this image under a different directory instead of the server's configured output directory"`
+	FilenameTemplate  string `json:"filename_template,omitempty" desc:"Go text/template for the output filename (fields: .Prompt, .Timestamp, .Index, .Hash); defaults to \"<prefix>-<timestamp>\""`
+}
+
+// GenerateImageOptions is the typed argument set for the generate_image
+// tool. It is unmarshaled directly from the JSON-RPC call's arguments and
+// also drives the tool's advertised JSON schema via schemaForOptions.
+type GenerateImageOptions struct {
+	Prompt         string    `json:"prompt" desc:"Text description of the image to generate" required:"true"`
+	NegativePrompt string    `json:"negative_prompt,omitempty" desc:"Things to avoid in the generated image"`
+	Seed           *int64    `json:"seed,omitempty" desc:"Seed for reproducible generation"`
+	AspectRatio    string    `json:"aspect_ratio,omitempty" desc:"Output aspect ratio" enum:"1:1,3:4,4:3,9:16,16:9"`
+	NumberOfImages int       `json:"number_of_images,omitempty" desc:"Number of images to generate (1-4)"`
+	OutputFormat   string    `json:"output_format,omitempty" desc:"Output image format" enum:"png,jpeg"`
+	CacheMode      CacheMode `json:"cache_mode,omitempty" desc:"Cache behavior: read_write (default), bypass, or refresh"`
+	SaveOptions
+}
+
+// EditImageOptions is the typed argument set for the edit_image tool.
+type EditImageOptions struct {
+	ImagePath      string    `json:"image_path" desc:"Path to the image file to edit" required:"true"`
+	Prompt         string    `json:"prompt" desc:"Text description of the edits to make" required:"true"`
+	NegativePrompt string    `json:"negative_prompt,omitempty" desc:"Things to avoid in the edited image"`
+	Seed           *int64    `json:"seed,omitempty" desc:"Seed for reproducible generation"`
+	AspectRatio    string    `json:"aspect_ratio,omitempty" desc:"Output aspect ratio" enum:"1:1,3:4,4:3,9:16,16:9"`
+	NumberOfImages int       `json:"number_of_images,omitempty" desc:"Number of images to generate (1-4)"`
+	OutputFormat   string    `json:"output_format,omitempty" desc:"Output image format" enum:"png,jpeg"`
+	CacheMode      CacheMode `json:"cache_mode,omitempty" desc:"Cache behavior: read_write (default), bypass, or refresh"`
+	SaveOptions
+}
+
+func (o *GenerateImageOptions) applyDefaults() {
+	if o.AspectRatio == "" {
+		o.AspectRatio = "1:1"
+	}
+	if o.NumberOfImages == 0 {
+		o.NumberOfImages = 1
+	}
+	if o.OutputFormat == "" {
+		o.OutputFormat = "png"
+	}
+	if o.CacheMode == "" {
+		o.CacheMode = CacheModeReadWrite
+	}
+}
+
+func (o GenerateImageOptions) Validate() error {
+	if o.Prompt == "" {
+		return fmt.Errorf("prompt is required")
+	}
+	return validateCommonImageOptions(o.NumberOfImages, o.AspectRatio, o.OutputFormat, o.CacheMode)
+}
+
+func (o *EditImageOptions) applyDefaults() {
+	if o.AspectRatio == "" {
+		o.AspectRatio = "1:1"
+	}
+	if o.NumberOfImages == 0 {
+		o.NumberOfImages = 1
+	}
+	if o.OutputFormat == "" {
+		o.OutputFormat = "png"
+	}
+	if o.CacheMode == "" {
+		o.CacheMode = CacheModeReadWrite
+	}
+}
+
+func (o EditImageOptions) Validate() error {
+	if o.ImagePath == "" {
+		return fmt.Errorf("image_path is required")
+	}
+	if o.Prompt == "" {
+		return fmt.Errorf("prompt is required")
+	}
+	return validateCommonImageOptions(o.NumberOfImages, o.AspectRatio, o.OutputFormat, o.CacheMode)
+}
+
+// ComposeImagesOptions is the typed argument set for the compose_images
+// tool. It combines several input images into one genai call guided by a
+// single prompt, rather than generating from or editing a single image.
+type ComposeImagesOptions struct {
+	ImagePaths     []string  `json:"image_paths" desc:"Paths to the 2-8 images to combine" required:"true" minItems:"2"`
+	Prompt         string    `json:"prompt" desc:"Text instructions for how to combine the images" required:"true"`
+	LayoutHint     string    `json:"layout_hint,omitempty" desc:"Optional hint about the desired layout, e.g. \"side by side\" or \"collage\""`
+	NegativePrompt string    `json:"negative_prompt,omitempty" desc:"Things to avoid in the composed image"`
+	Seed           *int64    `json:"seed,omitempty" desc:"Seed for reproducible generation"`
+	AspectRatio    string    `json:"aspect_ratio,omitempty" desc:"Output aspect ratio" enum:"1:1,3:4,4:3,9:16,16:9"`
+	OutputFormat   string    `json:"output_format,omitempty" desc:"Output image format" enum:"png,jpeg"`
+	CacheMode      CacheMode `json:"cache_mode,omitempty" desc:"Cache behavior: read_write (default), bypass, or refresh"`
+	SaveOptions
+}
+
+func (o *ComposeImagesOptions) applyDefaults() {
+	if o.AspectRatio == "" {
+		o.AspectRatio = "1:1"
+	}
+	if o.OutputFormat == "" {
+		o.OutputFormat = "png"
+	}
+	if o.CacheMode == "" {
+		o.CacheMode = CacheModeReadWrite
+	}
+}
+
+func (o ComposeImagesOptions) Validate() error {
+	if len(o.ImagePaths) < 2 || len(o.ImagePaths) > 8 {
+		return fmt.Errorf("image_paths must contain between 2 and 8 entries")
+	}
+	if o.Prompt == "" {
+		return fmt.Errorf("prompt is required")
+	}
+	return validateAspectRatioAndFormat(o.AspectRatio, o.OutputFormat, o.CacheMode)
+}
+
+// parseComposeImagesOptions is parseGenerateImageOptions for
+// ComposeImagesOptions.
+func parseComposeImagesOptions(args map[string]any) (ComposeImagesOptions, error) {
+	var opts ComposeImagesOptions
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return opts, fmt.Errorf("invalid arguments: %w", err)
+	}
+	if err := json.Unmarshal(raw, &opts); err != nil {
+		return opts, fmt.Errorf("invalid arguments: %w", err)
+	}
+	opts.applyDefaults()
+	if err := opts.Validate(); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+func validateCommonImageOptions(numberOfImages int, aspectRatio, outputFormat string, cacheMode CacheMode) error {
+	if numberOfImages < 1 || numberOfImages > 4 {
+		return fmt.Errorf("number_of_images must be between 1 and 4")
+	}
+	return validateAspectRatioAndFormat(aspectRatio, outputFormat, cacheMode)
+}
+
+// validateAspectRatioAndFormat is the part of validateCommonImageOptions
+// that doesn't depend on number_of_images, shared with tools that don't
+// have that field (e.g. compose_images).
+func validateAspectRatioAndFormat(aspectRatio, outputFormat string, cacheMode CacheMode) error {
+	switch aspectRatio {
+	case "1:1", "3:4", "4:3", "9:16", "16:9":
+	default:
+		return fmt.Errorf("aspect_ratio must be one of: 1:1, 3:4, 4:3, 9:16, 16:9")
+	}
+	switch outputFormat {
+	case "png", "jpeg":
+	default:
+		// webp is deliberately excluded: no Go WebP encoder is available
+		// (see imageformat.go), so advertising it would let a request pay
+		// for a full Gemini generation call before failing at the encode
+		// step.
+		return fmt.Errorf("output_format must be one of: png, jpeg")
+	}
+	return validateCacheMode(cacheMode)
+}
+
+// parseGenerateImageOptions unmarshals the JSON-RPC call's arguments
+// into GenerateImageOptions by round-tripping them through JSON, then
+// applies defaults and validates the result.
+func parseGenerateImageOptions(args map[string]any) (GenerateImageOptions, error) {
+	var opts GenerateImageOptions
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return opts, fmt.Errorf("invalid arguments: %w", err)
+	}
+	if err := json.Unmarshal(raw, &opts); err != nil {
+		return opts, fmt.Errorf("invalid arguments: %w", err)
+	}
+	opts.applyDefaults()
+	if err := opts.Validate(); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// parseEditImageOptions is parseGenerateImageOptions for EditImageOptions.
+func parseEditImageOptions(args map[string]any) (EditImageOptions, error) {
+	var opts EditImageOptions
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return opts, fmt.Errorf("invalid arguments: %w", err)
+	}
+	if err := json.Unmarshal(raw, &opts); err != nil {
+		return opts, fmt.Errorf("invalid arguments: %w", err)
+	}
+	opts.applyDefaults()
+	if err := opts.Validate(); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// buildPromptText folds the non-prompt generation knobs into the text
+// sent to Gemini, since gemini-2.5-flash-image takes these as plain
+// instructions rather than dedicated request fields.
+func buildPromptText(prompt, negativePrompt, aspectRatio string, seed *int64) string {
+	var b bytes.Buffer
+	b.WriteString(prompt)
+	if aspectRatio != "" && aspectRatio != "1:1" {
+		fmt.Fprintf(&b, "\n\nAspect ratio: %s", aspectRatio)
+	}
+	if negativePrompt != "" {
+		fmt.Fprintf(&b, "\n\nAvoid: %s", negativePrompt)
+	}
+	if seed != nil {
+		fmt.Fprintf(&b, "\n\nSeed: %d", *seed)
+	}
+	return b.String()
+}
+
+// filenameData is the set of fields available to a user-supplied
+// filename_template.
+type filenameData struct {
+	Prompt    string
+	Timestamp string
+	Index     int
+	Hash      string
+}
+
+// renderFilename returns the filename to save an image under. With no
+// template it reproduces the server's original "<prefix>-<timestamp>"
+// naming; with a template it executes it against data and appends ext if
+// the rendered name has no extension of its own.
+func renderFilename(tmplText, prefix, ext string, data filenameData) (string, error) {
+	if tmplText == "" {
+		return fmt.Sprintf("%s-%s%s", prefix, data.Timestamp, ext), nil
+	}
+
+	tmpl, err := template.New("filename").Parse(tmplText)
+	if err != nil {
+		return "", fmt.Errorf("invalid filename_template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("invalid filename_template: %w", err)
+	}
+
+	name := buf.String()
+	if filepath.Ext(name) == "" {
+		name += ext
+	}
+	return name, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGenerateImageOptionsDefaults(t *testing.T) {
+	opts, err := parseGenerateImageOptions(map[string]any{"prompt": "a cat"})
+	require.NoError(t, err)
+	require.Equal(t, "a cat", opts.Prompt)
+	require.Equal(t, "1:1", opts.AspectRatio)
+	require.Equal(t, 1, opts.NumberOfImages)
+	require.Equal(t, "png", opts.OutputFormat)
+	require.Equal(t, CacheModeReadWrite, opts.CacheMode)
+}
+
+func TestParseGenerateImageOptionsInvalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		args map[string]any
+	}{
+		{"bad aspect ratio", map[string]any{"prompt": "a cat", "aspect_ratio": "2:1"}},
+		{"bad output format", map[string]any{"prompt": "a cat", "output_format": "bmp"}},
+		{"webp output format", map[string]any{"prompt": "a cat", "output_format": "webp"}},
+		{"bad cache mode", map[string]any{"prompt": "a cat", "cache_mode": "nonsense"}},
+		{"too many images", map[string]any{"prompt": "a cat", "number_of_images": 5}},
+		{"negative images", map[string]any{"prompt": "a cat", "number_of_images": -1}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseGenerateImageOptions(tc.args)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestParseEditImageOptionsRequiresImagePath(t *testing.T) {
+	_, err := parseEditImageOptions(map[string]any{"prompt": "a cat"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "image_path is required")
+}
+
+func TestBuildPromptText(t *testing.T) {
+	seed := int64(42)
+	text := buildPromptText("a cat", "dogs", "16:9", &seed)
+	require.Contains(t, text, "a cat")
+	require.Contains(t, text, "Aspect ratio: 16:9")
+	require.Contains(t, text, "Avoid: dogs")
+	require.Contains(t, text, "Seed: 42")
+
+	require.Equal(t, "a cat", buildPromptText("a cat", "", "1:1", nil))
+}
+
+func TestRenderFilenameDefault(t *testing.T) {
+	name, err := renderFilename("", "generated", ".png", filenameData{Timestamp: "2026-01-01T00-00-00"})
+	require.NoError(t, err)
+	require.Equal(t, "generated-2026-01-01T00-00-00.png", name)
+}
+
+func TestRenderFilenameTemplate(t *testing.T) {
+	name, err := renderFilename("{{.Prompt}}-{{.Index}}", "generated", ".png", filenameData{Prompt: "a-cat", Index: 2})
+	require.NoError(t, err)
+	require.Equal(t, "a-cat-2.png", name)
+}
+
+func TestRenderFilenameInvalidTemplate(t *testing.T) {
+	_, err := renderFilename("{{.Nonexistent", "generated", ".png", filenameData{})
+	require.Error(t, err)
+}
+
+func TestParseComposeImagesOptionsDefaults(t *testing.T) {
+	opts, err := parseComposeImagesOptions(map[string]any{
+		"image_paths": []string{"a.png", "b.png"},
+		"prompt":      "combine these",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.png", "b.png"}, opts.ImagePaths)
+	require.Equal(t, "1:1", opts.AspectRatio)
+	require.Equal(t, "png", opts.OutputFormat)
+	require.Equal(t, CacheModeReadWrite, opts.CacheMode)
+}
+
+func TestParseComposeImagesOptionsInvalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		args map[string]any
+	}{
+		{"too few paths", map[string]any{"image_paths": []string{"a.png"}, "prompt": "combine"}},
+		{"too many paths", map[string]any{"image_paths": make([]string, 9), "prompt": "combine"}},
+		{"missing prompt", map[string]any{"image_paths": []string{"a.png", "b.png"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseComposeImagesOptions(tc.args)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestSchemaForOptionsComposeImagesHasArrayMinItems(t *testing.T) {
+	schema := schemaForOptions(ComposeImagesOptions{})
+	require.Contains(t, schema.Properties, "image_paths")
+	prop := schema.Properties["image_paths"]
+	require.Equal(t, "array", prop.Type)
+	require.Equal(t, 2, prop.MinItems)
+	require.NotNil(t, prop.Items)
+	require.Equal(t, "string", prop.Items.Type)
+}
+
+func TestSchemaForOptionsIncludesEmbeddedFields(t *testing.T) {
+	schema := schemaForOptions(GenerateImageOptions{})
+	require.Contains(t, schema.Properties, "prompt")
+	require.Contains(t, schema.Properties, "aspect_ratio")
+	require.Contains(t, schema.Properties, "output_dir_override", "embedded SaveOptions fields should be flattened")
+	require.Contains(t, schema.Required, "prompt")
+	require.ElementsMatch(t, []string{"1:1", "3:4", "4:3", "9:16", "16:9"}, schema.Properties["aspect_ratio"].Enum)
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ResourcesCapability advertises which optional resources behaviors this
+// server supports, mirroring ToolsCapability's role for tools.
+type ResourcesCapability struct {
+	ListChanged bool `json:"listChanged,omitempty"`
+	Subscribe   bool `json:"subscribe,omitempty"`
+}
+
+// Resource describes one file the server can serve through resources/read:
+// a generated/edited image under outputDir, or a cache sidecar.
+type Resource struct {
+	URI      string `json:"uri"`
+	Name     string `json:"name"`
+	MimeType string `json:"mimeType,omitempty"`
+}
+
+type ListResourcesResult struct {
+	Resources []Resource `json:"resources"`
+}
+
+type ReadResourceParams struct {
+	URI string `json:"uri"`
+}
+
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
+}
+
+type ReadResourceResult struct {
+	Contents []ResourceContents `json:"contents"`
+}
+
+type SubscribeResourceParams struct {
+	URI string `json:"uri"`
+}
+
+// handleListResources returns every resource the server currently knows
+// about, populated by the startup directory scan and by each successful
+// generate/edit call since.
+func (s *Server) handleListResources(req *JSONRPCRequest) *JSONRPCResponse {
+	s.mu.Lock()
+	resources := make([]Resource, 0, len(s.resources))
+	for _, r := range s.resources {
+		resources = append(resources, r)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(resources, func(i, j int) bool { return resources[i].URI < resources[j].URI })
+
+	return &JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: ListResourcesResult{Resources: resources}}
+}
+
+// handleReadResource returns a resource's contents: base64-encoded bytes
+// for images, raw JSON text for cache sidecars.
+func (s *Server) handleReadResource(req *JSONRPCRequest) *JSONRPCResponse {
+	var params ReadResourceParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return &JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Error: &Error{Code: -32602, Message: "Invalid params"}}
+	}
+
+	s.mu.Lock()
+	_, known := s.resources[params.URI]
+	s.mu.Unlock()
+	if !known {
+		return &JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Error: &Error{Code: -32002, Message: "Resource not found: " + params.URI}}
+	}
+
+	path, err := pathFromResourceURI(params.URI)
+	if err != nil {
+		return &JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Error: &Error{Code: -32602, Message: err.Error()}}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return &JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Error: &Error{Code: -32002, Message: "Resource not found: " + err.Error()}}
+	}
+
+	contents := ResourceContents{URI: params.URI, MimeType: mimeTypeForResourcePath(path)}
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		contents.Text = string(data)
+	} else {
+		contents.Blob = base64.StdEncoding.EncodeToString(data)
+	}
+
+	return &JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: ReadResourceResult{Contents: []ResourceContents{contents}}}
+}
+
+// handleSubscribeResource records that the calling session wants
+// notifications/resources/updated when the given URI is rewritten. It
+// requires a transport-attached session, since subscriptions are tracked
+// per session.
+func (s *Server) handleSubscribeResource(ctx context.Context, req *JSONRPCRequest) *JSONRPCResponse {
+	var params SubscribeResourceParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return &JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Error: &Error{Code: -32602, Message: "Invalid params"}}
+	}
+
+	sess, ok := sessionFromContext(ctx)
+	if !ok {
+		return &JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Error: &Error{Code: -32002, Message: "resources/subscribe requires a session"}}
+	}
+
+	s.mu.Lock()
+	if s.resourceSubs == nil {
+		s.resourceSubs = make(map[string]map[string]bool)
+	}
+	if s.resourceSubs[sess.ID] == nil {
+		s.resourceSubs[sess.ID] = make(map[string]bool)
+	}
+	s.resourceSubs[sess.ID][params.URI] = true
+	s.mu.Unlock()
+
+	return &JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: map[string]any{}}
+}
+
+// RegisterSession makes sess a target for broadcast notifications
+// (notifications/resources/list_changed and, if subscribed,
+// notifications/resources/updated). Transports call this once per
+// long-lived connection (the stdio loop, or an /events SSE stream).
+func (s *Server) RegisterSession(sess *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions == nil {
+		s.sessions = make(map[string]*Session)
+	}
+	s.sessions[sess.ID] = sess
+}
+
+// UnregisterSession removes a session registered with RegisterSession,
+// along with any resource subscriptions it held.
+func (s *Server) UnregisterSession(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	delete(s.resourceSubs, id)
+}
+
+// broadcastResourcesChanged notifies every registered session that the
+// resource list has changed.
+func (s *Server) broadcastResourcesChanged() {
+	s.mu.Lock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		if sess.Notify != nil {
+			sess.Notify(JSONRPCNotification{JSONRPC: "2.0", Method: "notifications/resources/list_changed"})
+		}
+	}
+}
+
+// notifyResourceUpdated notifies only the sessions subscribed to uri.
+func (s *Server) notifyResourceUpdated(uri string) {
+	s.mu.Lock()
+	var targets []*Session
+	for id, uris := range s.resourceSubs {
+		if uris[uri] {
+			if sess, ok := s.sessions[id]; ok {
+				targets = append(targets, sess)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, sess := range targets {
+		if sess.Notify != nil {
+			sess.Notify(JSONRPCNotification{
+				JSONRPC: "2.0",
+				Method:  "notifications/resources/updated",
+				Params:  map[string]any{"uri": uri},
+			})
+		}
+	}
+}
+
+// registerGeneratedResource records a freshly saved generate/edit output
+// as a resource, named from its prompt and save time. Callers are
+// responsible for broadcasting list_changed once per tool call.
+func (s *Server) registerGeneratedResource(path, prompt string, createdAt time.Time) {
+	s.registerResource(path, resourceName(prompt, createdAt))
+}
+
+// refreshCacheResource re-registers a cache entry's PNG and sidecar as
+// resources and, if any session is subscribed to either URI, notifies
+// them that the resource was rewritten (e.g. by a cache_mode=refresh
+// write over an existing digest).
+func (s *Server) refreshCacheResource(digest string) {
+	pngPath, jsonPath := s.cachePaths(digest)
+	for _, p := range []string{pngPath, jsonPath} {
+		absPath, err := filepath.Abs(p)
+		if err != nil {
+			continue
+		}
+		s.registerResource(absPath, resourceNameForPath(absPath))
+		s.notifyResourceUpdated(resourceURI(absPath))
+	}
+}
+
+// registerResource adds path to the resource catalog under the given
+// name, deriving its URI and MIME type from the path itself.
+func (s *Server) registerResource(path, name string) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+
+	r := Resource{
+		URI:      resourceURI(absPath),
+		Name:     name,
+		MimeType: mimeTypeForResourcePath(absPath),
+	}
+
+	s.mu.Lock()
+	if s.resources == nil {
+		s.resources = make(map[string]Resource)
+	}
+	s.resources[r.URI] = r
+	s.mu.Unlock()
+}
+
+// scanOutputDir walks the server's output directory (including the cache
+// subdirectory) and registers every file found, so images and sidecars
+// from prior runs are discoverable without waiting for a new generate/edit
+// call.
+func (s *Server) scanOutputDir() {
+	root := filepath.Join(".", s.outputDir)
+	if _, err := os.Stat(root); err != nil {
+		return
+	}
+
+	_ = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		s.registerResource(path, resourceNameForPath(path))
+		return nil
+	})
+}
+
+// resourceURI is the file:// URI the server advertises for an absolute
+// path, per the request's file:///<abs-path> convention.
+func resourceURI(absPath string) string {
+	return "file://" + absPath
+}
+
+// pathFromResourceURI is the inverse of resourceURI.
+func pathFromResourceURI(uri string) (string, error) {
+	const prefix = "file://"
+	if !strings.HasPrefix(uri, prefix) {
+		return "", fmt.Errorf("unsupported resource URI scheme: %q", uri)
+	}
+	return strings.TrimPrefix(uri, prefix), nil
+}
+
+// mimeTypeForResourcePath is getMimeType plus the one non-image case
+// resources need to handle: cache sidecar JSON.
+func mimeTypeForResourcePath(path string) string {
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		return "application/json"
+	}
+	return getMimeType(path)
+}
+
+// resourceName builds a short human-readable name for a resource from the
+// prompt that produced it and when it was saved.
+func resourceName(prompt string, createdAt time.Time) string {
+	const maxLen = 40
+	snippet := prompt
+	if len(snippet) > maxLen {
+		snippet = snippet[:maxLen] + "…"
+	}
+	return fmt.Sprintf("%s (%s)", snippet, createdAt.Format("2006-01-02T15:04:05"))
+}
+
+// resourceNameForPath derives a resource name for a file discovered on
+// disk rather than just saved: cache PNGs and sidecars carry their prompt
+// in the sidecar JSON, everything else falls back to its filename.
+func resourceNameForPath(path string) string {
+	sidecarPath := path
+	if strings.ToLower(filepath.Ext(path)) != ".json" {
+		sidecarPath = strings.TrimSuffix(path, filepath.Ext(path)) + ".json"
+	}
+
+	if data, err := os.ReadFile(sidecarPath); err == nil {
+		var sidecar cacheSidecar
+		if err := json.Unmarshal(data, &sidecar); err == nil && sidecar.Prompt != "" {
+			return resourceName(sidecar.Prompt, sidecar.CreatedAt)
+		}
+	}
+
+	return filepath.Base(path)
+}
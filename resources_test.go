@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateImageRegistersResourceAndBroadcastsListChanged(t *testing.T) {
+	fake := &fakeGeminiModels{result: fakeGenerateContentResponse(onePixelPNGBase64)}
+	s, _ := newCacheTestServer(t, fake)
+
+	var notifications []JSONRPCNotification
+	sess := &Session{ID: "test-session", Notify: func(n JSONRPCNotification) { notifications = append(notifications, n) }}
+	s.RegisterSession(sess)
+
+	_, err := s.generateImage(withSession(context.Background(), sess), map[string]any{"prompt": "a red circle"})
+	require.NoError(t, err)
+
+	// One resource for the saved image, plus two for the cache entry it
+	// wrote on the way (PNG + sidecar).
+	s.mu.Lock()
+	count := len(s.resources)
+	s.mu.Unlock()
+	require.Equal(t, 3, count)
+
+	// generateImage also emits notifications/progress frames; only count
+	// the list_changed notification.
+	var listChanged int
+	for _, n := range notifications {
+		if n.Method == "notifications/resources/list_changed" {
+			listChanged++
+		}
+	}
+	require.Equal(t, 1, listChanged)
+}
+
+func TestReadResourceImageReturnsBlob(t *testing.T) {
+	fake := &fakeGeminiModels{result: fakeGenerateContentResponse(onePixelPNGBase64)}
+	s, _ := newCacheTestServer(t, fake)
+
+	_, err := s.generateImage(context.Background(), map[string]any{"prompt": "a red circle"})
+	require.NoError(t, err)
+
+	listResp := s.handleListResources(&JSONRPCRequest{JSONRPC: "2.0", ID: 1})
+	result, ok := listResp.Result.(ListResourcesResult)
+	require.True(t, ok)
+	require.Len(t, result.Resources, 3)
+
+	var imageURI string
+	for _, r := range result.Resources {
+		if !strings.Contains(r.URI, "/cache/") {
+			imageURI = r.URI
+		}
+	}
+	require.NotEmpty(t, imageURI, "expected to find the top-level saved image among the resources")
+
+	params, err := json.Marshal(ReadResourceParams{URI: imageURI})
+	require.NoError(t, err)
+	readResp := s.handleReadResource(&JSONRPCRequest{JSONRPC: "2.0", ID: 2, Params: params})
+	require.Nil(t, readResp.Error)
+
+	readResult, ok := readResp.Result.(ReadResourceResult)
+	require.True(t, ok)
+	require.Len(t, readResult.Contents, 1)
+	require.NotEmpty(t, readResult.Contents[0].Blob)
+	require.Empty(t, readResult.Contents[0].Text)
+	require.Equal(t, "image/png", readResult.Contents[0].MimeType)
+}
+
+func TestReadResourceSidecarReturnsJSONText(t *testing.T) {
+	fake := &fakeGeminiModels{result: fakeGenerateContentResponse(onePixelPNGBase64)}
+	s, tmpDir := newCacheTestServer(t, fake)
+
+	_, err := s.generateImage(context.Background(), map[string]any{"prompt": "a red circle"})
+	require.NoError(t, err)
+
+	digest := cacheDigest(geminiModel, buildPromptText("a red circle", "", "1:1", nil), "", nil)
+	_, jsonPath := s.cachePaths(digest)
+	absJSONPath, err := filepath.Abs(jsonPath)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(tmpDir, "generated", "cache", digest+".json"), absJSONPath)
+
+	params, err := json.Marshal(ReadResourceParams{URI: resourceURI(absJSONPath)})
+	require.NoError(t, err)
+	readResp := s.handleReadResource(&JSONRPCRequest{JSONRPC: "2.0", ID: 1, Params: params})
+	require.Nil(t, readResp.Error)
+
+	readResult, ok := readResp.Result.(ReadResourceResult)
+	require.True(t, ok)
+	require.Len(t, readResult.Contents, 1)
+	require.Equal(t, "application/json", readResult.Contents[0].MimeType)
+	require.Contains(t, readResult.Contents[0].Text, "a red circle")
+}
+
+func TestResourcesSubscribeNotifiesOnCacheRefresh(t *testing.T) {
+	fake := &fakeGeminiModels{result: fakeGenerateContentResponse(onePixelPNGBase64)}
+	s, _ := newCacheTestServer(t, fake)
+
+	var notifications []JSONRPCNotification
+	sess := &Session{ID: "subscriber", Notify: func(n JSONRPCNotification) { notifications = append(notifications, n) }}
+	s.RegisterSession(sess)
+	ctx := withSession(context.Background(), sess)
+
+	_, err := s.generateImage(ctx, map[string]any{"prompt": "a red circle"})
+	require.NoError(t, err)
+
+	digest := cacheDigest(geminiModel, buildPromptText("a red circle", "", "1:1", nil), "", nil)
+	_, jsonPath := s.cachePaths(digest)
+	absJSONPath, err := filepath.Abs(jsonPath)
+	require.NoError(t, err)
+
+	subParams, err := json.Marshal(SubscribeResourceParams{URI: resourceURI(absJSONPath)})
+	require.NoError(t, err)
+	subResp := s.handleSubscribeResource(ctx, &JSONRPCRequest{JSONRPC: "2.0", ID: 2, Params: subParams})
+	require.Nil(t, subResp.Error)
+
+	notifications = nil
+	_, err = s.generateImage(ctx, map[string]any{"prompt": "a red circle", "cache_mode": "refresh"})
+	require.NoError(t, err)
+
+	var sawUpdate bool
+	for _, n := range notifications {
+		if n.Method == "notifications/resources/updated" {
+			sawUpdate = true
+		}
+	}
+	require.True(t, sawUpdate, "subscribed session should be notified when the cache entry is rewritten")
+}
+
+func TestReadResourceRejectsUnregisteredURI(t *testing.T) {
+	s, tmpDir := newCacheTestServer(t, &fakeGeminiModels{})
+
+	secretPath := filepath.Join(tmpDir, "secret.txt")
+	require.NoError(t, os.WriteFile(secretPath, []byte("top secret"), 0644))
+	absSecretPath, err := filepath.Abs(secretPath)
+	require.NoError(t, err)
+
+	params, err := json.Marshal(ReadResourceParams{URI: resourceURI(absSecretPath)})
+	require.NoError(t, err)
+	readResp := s.handleReadResource(&JSONRPCRequest{JSONRPC: "2.0", ID: 1, Params: params})
+
+	require.NotNil(t, readResp.Error)
+	require.Nil(t, readResp.Result)
+}
+
+func TestScanOutputDirDiscoversExistingFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	originalDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tmpDir))
+	t.Cleanup(func() { _ = os.Chdir(originalDir) })
+
+	require.NoError(t, os.MkdirAll(defaultOutputDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(defaultOutputDir, "generated-preexisting.png"), []byte("fake png bytes"), 0644))
+
+	s := &Server{outputDir: defaultOutputDir}
+	s.scanOutputDir()
+
+	s.mu.Lock()
+	count := len(s.resources)
+	s.mu.Unlock()
+	require.Equal(t, 1, count)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// schemaForOptions builds a tool's JSONSchema from an options struct via
+// reflection, so the schema advertised by tools/list can never drift
+// from the Go type that the tool actually unmarshals its arguments into.
+// Field metadata comes from the "json", "desc", "enum", "required" and
+// "minItems" struct tags; anonymous (embedded) fields are flattened.
+func schemaForOptions(v any) JSONSchema {
+	props := map[string]Property{}
+	var required []string
+	collectSchemaFields(reflect.TypeOf(v), props, &required)
+	return JSONSchema{Type: "object", Properties: props, Required: required}
+}
+
+func collectSchemaFields(t reflect.Type, props map[string]Property, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			collectSchemaFields(field.Type, props, required)
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		prop := Property{
+			Type:        jsonSchemaType(field.Type),
+			Description: field.Tag.Get("desc"),
+		}
+		if enumTag := field.Tag.Get("enum"); enumTag != "" {
+			prop.Enum = strings.Split(enumTag, ",")
+		}
+		if prop.Type == "array" {
+			itemType := jsonSchemaType(field.Type.Elem())
+			prop.Items = &Property{Type: itemType}
+		}
+		if minItemsTag := field.Tag.Get("minItems"); minItemsTag != "" {
+			if n, err := strconv.Atoi(minItemsTag); err == nil {
+				prop.MinItems = n
+			}
+		}
+		props[name] = prop
+
+		if field.Tag.Get("required") == "true" {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func jsonSchemaType(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "string"
+	}
+}
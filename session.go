@@ -0,0 +1,57 @@
+package main
+
+import "context"
+
+// JSONRPCNotification is a one-way JSON-RPC message the server sends to a
+// client outside the request/response cycle, such as progress updates
+// during a long-running tool call.
+type JSONRPCNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+// Session represents the connection a tool call is being served over, so
+// that code deep in a tool implementation (e.g. generateImage) can emit
+// notifications without the transport needing to know about tool internals.
+// Notify may be nil; every caller checks it before calling. StdioTransport's
+// Notify writes directly to stdout, HTTPTransport's enqueues onto the
+// client's SSE stream.
+type Session struct {
+	ID     string
+	Notify func(JSONRPCNotification)
+}
+
+type sessionContextKey struct{}
+
+// withSession returns a context carrying sess, retrievable with
+// sessionFromContext.
+func withSession(ctx context.Context, sess *Session) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, sess)
+}
+
+// sessionFromContext returns the Session a request is being served over, if
+// the transport attached one.
+func sessionFromContext(ctx context.Context) (*Session, bool) {
+	sess, ok := ctx.Value(sessionContextKey{}).(*Session)
+	return sess, ok
+}
+
+// notifyProgress emits a notifications/progress message over ctx's session,
+// if any. It is a no-op when ctx carries no session, so tool code can call
+// it unconditionally regardless of which transport is in use.
+func notifyProgress(ctx context.Context, current, total int, message string) {
+	sess, ok := sessionFromContext(ctx)
+	if !ok || sess.Notify == nil {
+		return
+	}
+	sess.Notify(JSONRPCNotification{
+		JSONRPC: "2.0",
+		Method:  "notifications/progress",
+		Params: map[string]any{
+			"progress": current,
+			"total":    total,
+			"message":  message,
+		},
+	})
+}
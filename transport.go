@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Transport serves a Server over some concrete protocol (stdio, HTTP+SSE,
+// ...) until ctx is canceled or a fatal transport error occurs.
+type Transport interface {
+	Serve(ctx context.Context, srv *Server) error
+}
+
+// StdioTransport serves a Server over newline-delimited JSON-RPC on
+// stdin/stdout, the original transport this server shipped with.
+type StdioTransport struct{}
+
+func (StdioTransport) Serve(ctx context.Context, srv *Server) error {
+	var writeMu sync.Mutex
+	write := func(v any) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		fmt.Println(string(b))
+		_ = os.Stdout.Sync()
+	}
+
+	sess := &Session{ID: "stdio", Notify: func(n JSONRPCNotification) { write(n) }}
+	srv.RegisterSession(sess)
+	defer srv.UnregisterSession(sess.ID)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		var req JSONRPCRequest
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			continue
+		}
+
+		resp := srv.handleRequest(withSession(ctx, sess), &req)
+		if resp == nil {
+			continue
+		}
+		write(resp)
+	}
+
+	return scanner.Err()
+}
+
+// httpSession is the server-side state for one /events SSE connection: a
+// buffered queue of notifications waiting to be flushed to that client.
+// Sends are non-blocking so a slow or gone client can't stall a tool call;
+// a full queue just drops the notification.
+type httpSession struct {
+	id    string
+	queue chan JSONRPCNotification
+}
+
+// HTTPTransport serves a Server over HTTP: JSON-RPC requests are POSTed to
+// /rpc, and notifications for a call (e.g. generation progress) are
+// delivered over a separate GET /events SSE stream, correlated by the
+// Mcp-Session-Id header. If AuthToken is set, both endpoints require a
+// matching "Authorization: Bearer <token>" header.
+type HTTPTransport struct {
+	Addr      string
+	AuthToken string
+
+	mu       sync.Mutex
+	sessions map[string]*httpSession
+}
+
+func NewHTTPTransport(addr, authToken string) *HTTPTransport {
+	return &HTTPTransport{Addr: addr, AuthToken: authToken, sessions: make(map[string]*httpSession)}
+}
+
+// handler builds the http.Handler serving srv's /healthz, /events and /rpc
+// endpoints. It is split out from Serve so tests can drive it directly with
+// httptest.Server instead of binding a real port.
+func (t *HTTPTransport) handler(srv *Server) http.Handler {
+	if t.sessions == nil {
+		t.sessions = make(map[string]*httpSession)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", t.handleHealthz)
+	mux.HandleFunc("/events", t.authorize(func(w http.ResponseWriter, r *http.Request) {
+		t.handleEvents(w, r, srv)
+	}))
+	mux.HandleFunc("/rpc", t.authorize(func(w http.ResponseWriter, r *http.Request) {
+		t.handleRPC(w, r, srv)
+	}))
+	return mux
+}
+
+func (t *HTTPTransport) Serve(ctx context.Context, srv *Server) error {
+	httpServer := &http.Server{Addr: t.Addr, Handler: t.handler(srv)}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- httpServer.ListenAndServe() }()
+
+	select {
+	case <-ctx.Done():
+		return httpServer.Shutdown(context.Background())
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}
+}
+
+// authorize wraps h with bearer-token auth when t.AuthToken is set; it is a
+// no-op wrapper otherwise.
+func (t *HTTPTransport) authorize(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if t.AuthToken != "" && !constantTimeEqual(r.Header.Get("Authorization"), "Bearer "+t.AuthToken) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// constantTimeEqual compares two strings without leaking their length of
+// shared prefix through timing, as is standard for any check gating
+// network access against a secret token.
+func constantTimeEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func (t *HTTPTransport) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+// handleEvents opens an SSE stream for one client, first sending the
+// session ID it was assigned so the client can attach it to subsequent
+// POST /rpc calls via the Mcp-Session-Id header.
+func (t *HTTPTransport) handleEvents(w http.ResponseWriter, r *http.Request, srv *Server) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sess := &httpSession{id: newSessionID(), queue: make(chan JSONRPCNotification, 32)}
+	t.registerSession(sess)
+	defer t.unregisterSession(sess.id)
+
+	srv.RegisterSession(&Session{
+		ID: sess.id,
+		Notify: func(n JSONRPCNotification) {
+			select {
+			case sess.queue <- n:
+			default:
+			}
+		},
+	})
+	defer srv.UnregisterSession(sess.id)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Mcp-Session-Id", sess.id)
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "event: session\ndata: %s\n\n", sess.id)
+	flusher.Flush()
+
+	for {
+		select {
+		case n := <-sess.queue:
+			b, err := json.Marshal(n)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", b)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleRPC serves a single JSON-RPC request. If the request carries an
+// Mcp-Session-Id header matching an open /events stream, notifications the
+// call emits (e.g. generation progress) are forwarded to that stream.
+func (t *HTTPTransport) handleRPC(w http.ResponseWriter, r *http.Request, srv *Server) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req JSONRPCRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if sess := t.lookupSession(r.Header.Get("Mcp-Session-Id")); sess != nil {
+		ctx = withSession(ctx, &Session{
+			ID: sess.id,
+			Notify: func(n JSONRPCNotification) {
+				select {
+				case sess.queue <- n:
+				default:
+				}
+			},
+		})
+	}
+
+	resp := srv.handleRequest(ctx, &req)
+	if resp == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (t *HTTPTransport) registerSession(sess *httpSession) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sessions[sess.id] = sess
+}
+
+func (t *HTTPTransport) unregisterSession(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.sessions, id)
+}
+
+func (t *HTTPTransport) lookupSession(id string) *httpSession {
+	if id == "" {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sessions[id]
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
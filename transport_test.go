@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConstantTimeEqual(t *testing.T) {
+	require.True(t, constantTimeEqual("Bearer secret", "Bearer secret"))
+	require.False(t, constantTimeEqual("Bearer secret", "Bearer wrong"))
+	require.False(t, constantTimeEqual("Bearer secret", "Bearer secrets"))
+	require.False(t, constantTimeEqual("", "Bearer secret"))
+}
+
+func rpcRequest(t *testing.T, server *httptest.Server, sessionID string, req JSONRPCRequest) *JSONRPCResponse {
+	t.Helper()
+	body, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	httpReq, err := http.NewRequest(http.MethodPost, server.URL+"/rpc", bytes.NewReader(body))
+	require.NoError(t, err)
+	if sessionID != "" {
+		httpReq.Header.Set("Mcp-Session-Id", sessionID)
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var rpcResp JSONRPCResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rpcResp))
+	return &rpcResp
+}
+
+func TestHTTPTransportInitializeListCall(t *testing.T) {
+	srv := newCacheTestServerForHTTP(t)
+	transport := &HTTPTransport{}
+	httpSrv := httptest.NewServer(transport.handler(srv))
+	defer httpSrv.Close()
+
+	initResp := rpcRequest(t, httpSrv, "", JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "initialize"})
+	require.Nil(t, initResp.Error)
+
+	listResp := rpcRequest(t, httpSrv, "", JSONRPCRequest{JSONRPC: "2.0", ID: 2, Method: "tools/list"})
+	require.Nil(t, listResp.Error)
+
+	params, err := json.Marshal(CallToolParams{Name: "generate_image", Arguments: map[string]any{"prompt": "a red circle"}})
+	require.NoError(t, err)
+	callResp := rpcRequest(t, httpSrv, "", JSONRPCRequest{JSONRPC: "2.0", ID: 3, Method: "tools/call", Params: params})
+	require.Nil(t, callResp.Error)
+}
+
+func TestHTTPTransportHealthz(t *testing.T) {
+	transport := &HTTPTransport{}
+	httpSrv := httptest.NewServer(transport.handler(newCacheTestServerForHTTP(t)))
+	defer httpSrv.Close()
+
+	resp, err := http.Get(httpSrv.URL + "/healthz")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestHTTPTransportRequiresAuthToken(t *testing.T) {
+	transport := &HTTPTransport{AuthToken: "secret"}
+	httpSrv := httptest.NewServer(transport.handler(newCacheTestServerForHTTP(t)))
+	defer httpSrv.Close()
+
+	body, err := json.Marshal(JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "initialize"})
+	require.NoError(t, err)
+
+	resp, err := http.Post(httpSrv.URL+"/rpc", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+// TestHTTPTransportProgressOverSSE drives an /events stream and a
+// multi-image generate_image call concurrently, and asserts that a
+// notifications/progress frame emitted during the call reaches the stream
+// within two seconds of the tools/call response. Arrival order relative to
+// the response is not checked, since the two travel over separate
+// connections.
+func TestHTTPTransportProgressOverSSE(t *testing.T) {
+	srv := newCacheTestServerForHTTP(t)
+	transport := &HTTPTransport{}
+	httpSrv := httptest.NewServer(transport.handler(srv))
+	defer httpSrv.Close()
+
+	sseReq, err := http.NewRequest(http.MethodGet, httpSrv.URL+"/events", nil)
+	require.NoError(t, err)
+	sseResp, err := http.DefaultClient.Do(sseReq)
+	require.NoError(t, err)
+	defer sseResp.Body.Close()
+	require.Equal(t, http.StatusOK, sseResp.StatusCode)
+
+	sessionID := sseResp.Header.Get("Mcp-Session-Id")
+	require.NotEmpty(t, sessionID)
+
+	progressCh := make(chan string, 8)
+	go func() {
+		scanner := bufio.NewScanner(sseResp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "data: ") && strings.Contains(line, "notifications/progress") {
+				progressCh <- line
+			}
+		}
+	}()
+
+	params, err := json.Marshal(CallToolParams{
+		Name:      "generate_image",
+		Arguments: map[string]any{"prompt": "a red circle", "number_of_images": 3},
+	})
+	require.NoError(t, err)
+
+	callResp := rpcRequest(t, httpSrv, sessionID, JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "tools/call", Params: params})
+	require.Nil(t, callResp.Error)
+
+	select {
+	case <-progressCh:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected at least one notifications/progress frame over SSE")
+	}
+}
+
+func newCacheTestServerForHTTP(t *testing.T) *Server {
+	t.Helper()
+	fake := &fakeGeminiModels{result: fakeGenerateContentResponse(onePixelPNGBase64)}
+	s, _ := newCacheTestServer(t, fake)
+	return s
+}